@@ -114,6 +114,7 @@ var (
 		utils.CacheTrieJournalFlag,
 		utils.CacheTrieRejournalFlag,
 		utils.CacheGCFlag,
+		utils.CacheTriesInMemoryFlag,
 		utils.CacheSnapshotFlag,
 		utils.CacheNoPrefetchFlag,
 		utils.CachePreimagesFlag,
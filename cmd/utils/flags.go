@@ -50,6 +50,7 @@ import (
 	"github.com/ethereum/go-ethereum/ethdb/remotedb"
 	"github.com/ethereum/go-ethereum/ethstats"
 	"github.com/ethereum/go-ethereum/graphql"
+	"github.com/ethereum/go-ethereum/internal/debug"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/internal/flags"
 	"github.com/ethereum/go-ethereum/les"
@@ -477,6 +478,12 @@ var (
 		Value:    25,
 		Category: flags.PerfCategory,
 	}
+	CacheTriesInMemoryFlag = &cli.Uint64Flag{
+		Name:     "cache.triesinmemory",
+		Usage:    "Number of recent block tries to keep in memory before garbage collecting them",
+		Value:    ethconfig.Defaults.TriesInMemory,
+		Category: flags.PerfCategory,
+	}
 	CacheSnapshotFlag = &cli.IntFlag{
 		Name:     "cache.snapshot",
 		Usage:    "Percentage of cache memory allowance to use for snapshot caching (default = 10% full mode, 20% archive mode)",
@@ -1762,12 +1769,15 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 			ctx.Set(CacheFlag.Name, strconv.Itoa(allowance))
 		}
 	}
-	// Ensure Go's GC ignores the database cache for trigger percentage
-	cache := ctx.Int(CacheFlag.Name)
-	gogc := math.Max(20, math.Min(100, 100/(float64(cache)/1024)))
+	// Ensure Go's GC ignores the database cache for trigger percentage, unless
+	// the user pinned it explicitly via the debug flags.
+	if !debug.GCPercentSet(ctx) {
+		cache := ctx.Int(CacheFlag.Name)
+		gogc := math.Max(20, math.Min(100, 100/(float64(cache)/1024)))
 
-	log.Debug("Sanitizing Go's GC trigger", "percent", int(gogc))
-	godebug.SetGCPercent(int(gogc))
+		log.Debug("Sanitizing Go's GC trigger", "percent", int(gogc))
+		godebug.SetGCPercent(int(gogc))
+	}
 
 	if ctx.IsSet(SyncModeFlag.Name) {
 		cfg.SyncMode = *flags.GlobalTextMarshaler(ctx, SyncModeFlag.Name).(*downloader.SyncMode)
@@ -1813,6 +1823,12 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	if ctx.IsSet(CacheFlag.Name) || ctx.IsSet(CacheGCFlag.Name) {
 		cfg.TrieDirtyCache = ctx.Int(CacheFlag.Name) * ctx.Int(CacheGCFlag.Name) / 100
 	}
+	if ctx.IsSet(CacheTriesInMemoryFlag.Name) {
+		if cfg.TriesInMemory = ctx.Uint64(CacheTriesInMemoryFlag.Name); cfg.TriesInMemory < 1 {
+			Fatalf("--%s must be at least 1", CacheTriesInMemoryFlag.Name)
+		}
+		log.Info("Using custom trie retention", "triesinmemory", cfg.TriesInMemory, "source", "--"+CacheTriesInMemoryFlag.Name)
+	}
 	if ctx.IsSet(CacheFlag.Name) || ctx.IsSet(CacheSnapshotFlag.Name) {
 		cfg.SnapshotCache = ctx.Int(CacheFlag.Name) * ctx.Int(CacheSnapshotFlag.Name) / 100
 	}
@@ -2189,9 +2205,13 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		TrieDirtyLimit:      ethconfig.Defaults.TrieDirtyCache,
 		TrieDirtyDisabled:   ctx.String(GCModeFlag.Name) == "archive",
 		TrieTimeLimit:       ethconfig.Defaults.TrieTimeout,
+		TriesInMemory:       ctx.Uint64(CacheTriesInMemoryFlag.Name),
 		SnapshotLimit:       ethconfig.Defaults.SnapshotCache,
 		Preimages:           ctx.Bool(CachePreimagesFlag.Name),
 	}
+	if cache.TriesInMemory < 1 {
+		Fatalf("--%s must be at least 1", CacheTriesInMemoryFlag.Name)
+	}
 	if cache.TrieDirtyDisabled && !cache.Preimages {
 		cache.Preimages = true
 		log.Info("Enabling recording of key preimages since archive mode is used")
@@ -39,6 +39,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/internal/pprofx"
 	"github.com/ethereum/go-ethereum/internal/syncx"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
@@ -73,6 +74,11 @@ var (
 	blockExecutionTimer  = metrics.NewRegisteredTimer("chain/execution", nil)
 	blockWriteTimer      = metrics.NewRegisteredTimer("chain/write", nil)
 
+	// blockInsertHistogram tracks the same latency as blockInsertTimer in
+	// seconds, bucketed for latency SLOs based on the Prometheus histogram.
+	blockInsertHistogram = metrics.NewRegisteredBucketHistogram("chain/inserts/seconds", nil,
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+
 	blockReorgMeter         = metrics.NewRegisteredMeter("chain/reorg/executes", nil)
 	blockReorgAddMeter      = metrics.NewRegisteredMeter("chain/reorg/add", nil)
 	blockReorgDropMeter     = metrics.NewRegisteredMeter("chain/reorg/drop", nil)
@@ -1732,9 +1738,17 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals, setHead bool)
 			}
 		}
 
-		// Process block using the parent state as reference point
+		// Process block using the parent state as reference point. The phases
+		// are labeled so CPU profiles can be broken down by them.
+		var (
+			receipts types.Receipts
+			logs     []*types.Log
+			usedGas  uint64
+		)
 		substart := time.Now()
-		receipts, logs, usedGas, err := bc.processor.Process(block, statedb, bc.vmConfig)
+		pprofx.Do("phase", "process", func() {
+			receipts, logs, usedGas, err = bc.processor.Process(block, statedb, bc.vmConfig)
+		})
 		if err != nil {
 			bc.reportBlock(block, receipts, err)
 			atomic.StoreUint32(&followupInterrupt, 1)
@@ -1756,7 +1770,10 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals, setHead bool)
 
 		// Validate the state using the default validator
 		substart = time.Now()
-		if err := bc.validator.ValidateState(block, statedb, receipts, usedGas); err != nil {
+		pprofx.Do("phase", "validate", func() {
+			err = bc.validator.ValidateState(block, statedb, receipts, usedGas)
+		})
+		if err != nil {
 			bc.reportBlock(block, receipts, err)
 			atomic.StoreUint32(&followupInterrupt, 1)
 			return it.index, err
@@ -1771,12 +1788,14 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals, setHead bool)
 		// Write the block to the chain and get the status.
 		substart = time.Now()
 		var status WriteStatus
-		if !setHead {
-			// Don't set the head, only insert the block
-			err = bc.writeBlockWithState(block, receipts, statedb)
-		} else {
-			status, err = bc.writeBlockAndSetHead(block, receipts, logs, statedb, false)
-		}
+		pprofx.Do("phase", "write", func() {
+			if !setHead {
+				// Don't set the head, only insert the block
+				err = bc.writeBlockWithState(block, receipts, statedb)
+			} else {
+				status, err = bc.writeBlockAndSetHead(block, receipts, logs, statedb, false)
+			}
+		})
 		atomic.StoreUint32(&followupInterrupt, 1)
 		if err != nil {
 			return it.index, err
@@ -1788,6 +1807,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals, setHead bool)
 
 		blockWriteTimer.Update(time.Since(substart) - statedb.AccountCommits - statedb.StorageCommits - statedb.SnapshotCommits)
 		blockInsertTimer.UpdateSince(start)
+		blockInsertHistogram.Update(time.Since(start).Seconds())
 
 		// Report the import stats before returning the various results
 		stats.processed++
@@ -116,6 +116,10 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 		log.Warn("Sanitizing invalid miner gas price", "provided", config.Miner.GasPrice, "updated", ethconfig.Defaults.Miner.GasPrice)
 		config.Miner.GasPrice = new(big.Int).Set(ethconfig.Defaults.Miner.GasPrice)
 	}
+	if config.TriesInMemory == 0 {
+		// Configs not derived from ethconfig.Defaults leave this unset
+		config.TriesInMemory = core.DefaultTriesInMemory
+	}
 	if config.NoPruning && config.TrieDirtyCache > 0 {
 		if config.SnapshotCache > 0 {
 			config.TrieCleanCache += config.TrieDirtyCache * 3 / 5
@@ -193,7 +197,7 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 		cacheConfig = &core.CacheConfig{
 
 			// Arbitrum
-			TriesInMemory: 128,
+			TriesInMemory: config.TriesInMemory,
 			TrieRetention: 30 * time.Minute,
 
 			TrieCleanLimit:      config.TrieCleanCache,
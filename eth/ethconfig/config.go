@@ -82,6 +82,7 @@ var Defaults = Config{
 	TrieCleanCacheRejournal: 60 * time.Minute,
 	TrieDirtyCache:          256,
 	TrieTimeout:             60 * time.Minute,
+	TriesInMemory:           core.DefaultTriesInMemory,
 	SnapshotCache:           102,
 	FilterLogCacheSize:      32,
 	Miner: miner.Config{
@@ -169,6 +170,7 @@ type Config struct {
 	TrieCleanCacheRejournal time.Duration `toml:",omitempty"` // Time interval to regenerate the journal for clean cache
 	TrieDirtyCache          int
 	TrieTimeout             time.Duration
+	TriesInMemory           uint64 // Height difference before which a trie may not be garbage-collected
 	SnapshotCache           int
 	Preimages               bool
 
@@ -46,6 +46,7 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		TrieCleanCacheRejournal               time.Duration `toml:",omitempty"`
 		TrieDirtyCache                        int
 		TrieTimeout                           time.Duration
+		TriesInMemory                         uint64
 		SnapshotCache                         int
 		Preimages                             bool
 		FilterLogCacheSize                    int
@@ -92,6 +93,7 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.TrieCleanCacheRejournal = c.TrieCleanCacheRejournal
 	enc.TrieDirtyCache = c.TrieDirtyCache
 	enc.TrieTimeout = c.TrieTimeout
+	enc.TriesInMemory = c.TriesInMemory
 	enc.SnapshotCache = c.SnapshotCache
 	enc.Preimages = c.Preimages
 	enc.FilterLogCacheSize = c.FilterLogCacheSize
@@ -142,6 +144,7 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		TrieCleanCacheRejournal               *time.Duration `toml:",omitempty"`
 		TrieDirtyCache                        *int
 		TrieTimeout                           *time.Duration
+		TriesInMemory                         *uint64
 		SnapshotCache                         *int
 		Preimages                             *bool
 		FilterLogCacheSize                    *int
@@ -247,6 +250,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.TrieTimeout != nil {
 		c.TrieTimeout = *dec.TrieTimeout
 	}
+	if dec.TriesInMemory != nil {
+		c.TriesInMemory = *dec.TriesInMemory
+	}
 	if dec.SnapshotCache != nil {
 		c.SnapshotCache = *dec.SnapshotCache
 	}
@@ -96,6 +96,31 @@ func (h *HandlerT) CpuProfile(file string, nsec uint) error {
 	return nil
 }
 
+// cpuProfileRate is the CPU profiling rate in Hz set by --pprof.cpuprofile.rate,
+// 0 to use the runtime default of 100 Hz.
+var cpuProfileRate int
+
+const (
+	defaultCPUProfileRate = 100     // Rate used by pprof.StartCPUProfile
+	maxCPUProfileRate     = 1000000 // Highest rate supported by the runtime
+)
+
+// startCPUProfile starts CPU profiling into w, sampling at the given rate in Hz
+// or at the runtime default if it is zero.
+//
+// runtime/pprof offers no way to pick the rate, so a custom one is set before
+// starting the profile. pprof.StartCPUProfile then fails to apply the default
+// and the runtime prints the known, harmless warning "cannot set cpu profile
+// rate until previous profile has finished" to stderr. pprof.StopCPUProfile
+// turns profiling off entirely, so the default applies again to the next
+// profile started without a custom rate.
+func startCPUProfile(w io.Writer, rate int) error {
+	if rate > 0 && rate != defaultCPUProfileRate {
+		runtime.SetCPUProfileRate(rate)
+	}
+	return pprof.StartCPUProfile(w)
+}
+
 // StartCPUProfile turns on CPU profiling, writing to the given file.
 func (h *HandlerT) StartCPUProfile(file string) error {
 	h.mu.Lock()
@@ -107,7 +132,7 @@ func (h *HandlerT) StartCPUProfile(file string) error {
 	if err != nil {
 		return err
 	}
-	if err := pprof.StartCPUProfile(f); err != nil {
+	if err := startCPUProfile(f, cpuProfileRate); err != nil {
 		f.Close()
 		return err
 	}
@@ -117,18 +142,24 @@ func (h *HandlerT) StartCPUProfile(file string) error {
 	return nil
 }
 
-// StopCPUProfile stops an ongoing CPU profile.
+// StopCPUProfile stops an ongoing CPU profile. If --pprof.upload.url is set,
+// the profile is uploaded before returning, but without holding the handler
+// lock, so other profiling calls are not held up by the transfer.
 func (h *HandlerT) StopCPUProfile() error {
 	h.mu.Lock()
-	defer h.mu.Unlock()
 	pprof.StopCPUProfile()
 	if h.cpuW == nil {
+		h.mu.Unlock()
 		return errors.New("CPU profiling not in progress")
 	}
 	log.Info("Done writing CPU profile", "dump", h.cpuFile)
 	h.cpuW.Close()
+	file := h.cpuFile
 	h.cpuW = nil
 	h.cpuFile = ""
+	h.mu.Unlock()
+
+	uploadProfile(expandHome(file))
 	return nil
 }
 
@@ -0,0 +1,105 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debug
+
+import "time"
+
+// Config contains the logging, profiling and tracing settings applied by
+// SetupWith. It mirrors the command line flags accepted by Setup.
+type Config struct {
+	Verbosity        int           // Log verbosity: 0=silent, 1=error, 2=warn, 3=info, 4=debug, 5=detail
+	Vmodule          string        // Per-module verbosity pattern (e.g. eth/*=5,p2p=4)
+	VmoduleFiles     []string      // Files of vmodule patterns merged before Vmodule
+	LogFormat        string        // Log format (terminal, json, logfmt or gcp), auto-detected if empty
+	LogJSON          bool          // Format logs with JSON if no LogFormat is set
+	LogJSONFieldMax  int           // Maximum length of JSON string values in bytes, 0 if unlimited
+	LogTimeFormat    string        // Go time layout for log timestamps, format specific if empty
+	LogNoColor       bool          // Disable colored terminal log output, same as a "never" LogColor
+	LogColor         string        // Terminal log color mode (auto, always or never), auto if empty
+	LogSplit         bool          // Send errors to stderr and all other records to stdout
+	LogFile          string        // File to additionally write logs to, rotated by size
+	LogFileOnly      bool          // Write logs only to LogFile, not to stderr
+	LogFileVerbosity *int          // Verbosity of LogFile, nil to use Verbosity
+	LogMaxSize       int           // Maximum size in megabytes of LogFile before rotating
+	LogMaxBackups    int           // Maximum number of rotated log files to retain
+	LogSyslog        bool          // Additionally write logs to syslog
+	LogSyslogNetwork string        // Network of the remote syslog daemon
+	LogSyslogAddr    string        // Address of the remote syslog daemon, local syslog if empty
+	LogJournald      bool          // Send logs to the systemd journal (Linux only)
+	LogSampleRate    time.Duration // Interval over which repeated log messages are sampled, 0 to disable
+	LogSampleBurst   int           // Number of identical log messages let through per sampling interval
+	LogDedupWindow   time.Duration // Window within which identical log records are suppressed, 0 to disable
+	LogContext       string        // Comma-separated key=value pairs attached to every log record
+	Debug            bool          // Prepend log messages with call-site location
+	BacktraceAt      string        // Request a stack trace at a specific logging statement
+
+	MemProfileRate       int           // Memory profiling rate, 0 to disable
+	BlockProfileRate     int           // Block profiling rate, 0 to disable
+	MutexProfileFraction int           // Mutex profiling fraction, 0 to disable
+	GCPercent            *int          // Garbage collection target percentage, -1 disables GC, nil keeps the default
+	MemoryLimit          int64         // Soft memory limit of the Go runtime in bytes, 0 for no limit
+	CPUProfile           string        // File to write a CPU profile to
+	CPUProfileDuration   time.Duration // Stop the CPU profile after this duration, 0 to run until exit
+	CPUProfileRate       int           // CPU profiling rate in Hz, 0 for the runtime default
+	ProfileLabels        string        // Comma-separated key=value pprof labels attached to all goroutines
+	ProfileOverwrite     bool          // Allow CPUProfile and Trace to overwrite existing files
+	ProfileUploadURL     string        // s3:// or http(s):// location finished profiles are uploaded to
+	Trace                string        // File to write an execution trace to
+	TraceRotateDuration  time.Duration // Start a new numbered trace file after this duration, 0 for a single file
+	HeapDumpDir          string        // Directory for heap dumps on SIGUSR1 and goroutine dumps on SIGUSR2
+	HeapDumpThreshold    uint64        // Heap size in MiB above which a heap dump is written, 0 to disable
+	SnapshotOnSignal     bool          // Write a full profile snapshot instead of a heap dump on SIGUSR1
+	DumpsKeep            int           // Number of dumps of each kind kept in HeapDumpDir, 0 to keep all
+	GoRuntimeMetrics     bool          // Collect Go runtime metrics
+
+	Pprof             bool          // Enable the pprof HTTP server
+	PprofAddr         string        // pprof HTTP server listening interface, or unix:<path>
+	PprofPort         int           // pprof HTTP server listening port
+	PprofWithMetrics  bool          // Serve go-metrics via expvar on the pprof server
+	PprofPrometheus   bool          // Serve Prometheus metrics on the pprof server
+	PprofExpvarLazy   bool          // Sync go-metrics into expvar in the background
+	PprofStartDelay   time.Duration // Delay before the pprof server starts listening, 0 for none
+	PprofReadTimeout  time.Duration // Maximum time to read request headers on the pprof server, 0 for none
+	PprofWriteTimeout time.Duration // Maximum time to write a pprof server response, 0 for none
+	PprofRateLimit    float64       // Maximum requests per second served by the pprof server, 0 if unlimited
+	PprofTLSCert      string        // TLS certificate file for the pprof HTTP server
+	PprofTLSKey       string        // TLS private key file for the pprof HTTP server
+	PprofAuthUser     string        // Username required to access the pprof HTTP server
+	PprofAuthPassword string        // Password required to access the pprof HTTP server
+	PprofRedact       []string      // Flags whose values are redacted from /debug/pprof/cmdline
+	PprofSharedMux    bool          // Serve the pprof endpoints from http.DefaultServeMux
+	PprofMemsizePath  string        // Path the memsize handler is mounted at, empty to disable it
+}
+
+// DefaultConfig contains the default settings, matching the defaults of the
+// command line flags.
+var DefaultConfig = Config{
+	Verbosity:         verbosityFlag.Value,
+	LogMaxSize:        logMaxSizeFlag.Value,
+	LogMaxBackups:     logMaxBackupsFlag.Value,
+	LogSyslogNetwork:  logSyslogNetworkFlag.Value,
+	LogSampleBurst:    logSampleBurstFlag.Value,
+	LogJSONFieldMax:   logJSONMaxFieldSizeFlag.Value,
+	MemProfileRate:    memprofilerateFlag.Value,
+	PprofAddr:         pprofAddrFlag.Value,
+	PprofPort:         pprofPortFlag.Value,
+	PprofWithMetrics:  true,
+	PprofRedact:       []string{pprofAuthUserFlag.Name, pprofAuthPasswordFlag.Name},
+	PprofMemsizePath:  pprofMemsizePathFlag.Value,
+	PprofReadTimeout:  pprofReadTimeoutFlag.Value,
+	PprofWriteTimeout: pprofWriteTimeoutFlag.Value,
+}
@@ -17,17 +17,28 @@
 package debug
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
-	_ "net/http/pprof" // nolint: gosec
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/ethereum/go-ethereum/internal/flags"
+	"github.com/ethereum/go-ethereum/internal/pprofx"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/metrics/exp"
+	"github.com/ethereum/go-ethereum/metrics/prometheus"
 	"github.com/fjl/memsize/memsizeui"
 	"github.com/mattn/go-colorable"
 	"github.com/mattn/go-isatty"
@@ -40,67 +51,379 @@ var (
 	verbosityFlag = &cli.IntFlag{
 		Name:     "verbosity",
 		Usage:    "Logging verbosity: 0=silent, 1=error, 2=warn, 3=info, 4=debug, 5=detail",
+		EnvVars:  []string{"GETH_VERBOSITY"},
 		Value:    3,
 		Category: flags.LoggingCategory,
 	}
 	vmoduleFlag = &cli.StringFlag{
 		Name:     "vmodule",
 		Usage:    "Per-module verbosity: comma-separated list of <pattern>=<level> (e.g. eth/*=5,p2p=4)",
+		EnvVars:  []string{"GETH_VMODULE"},
 		Value:    "",
 		Category: flags.LoggingCategory,
 	}
+	vmoduleFileFlag = &cli.StringSliceFlag{
+		Name:     "vmodule.file",
+		Usage:    "File of newline-separated <pattern>=<level> entries merged before --vmodule (may be repeated)",
+		EnvVars:  []string{"GETH_VMODULE_FILE"},
+		Category: flags.LoggingCategory,
+	}
 	logjsonFlag = &cli.BoolFlag{
 		Name:     "log.json",
 		Usage:    "Format logs with JSON",
+		EnvVars:  []string{"GETH_LOG_JSON"},
+		Category: flags.LoggingCategory,
+	}
+	logFormatFlag = &cli.StringFlag{
+		Name:     "log.format",
+		Usage:    "Log format to use (terminal, json, logfmt or gcp), auto-detected if unset",
+		EnvVars:  []string{"GETH_LOG_FORMAT"},
+		Category: flags.LoggingCategory,
+	}
+	logJSONMaxFieldSizeFlag = &cli.IntFlag{
+		Name:     "log.json.maxfieldsize",
+		Usage:    "Truncate string values of JSON log records longer than this many bytes (0 = unlimited)",
+		EnvVars:  []string{"GETH_LOG_JSON_MAXFIELDSIZE"},
+		Value:    64 * 1024,
+		Category: flags.LoggingCategory,
+	}
+	logTimeFormatFlag = &cli.StringFlag{
+		Name:     "log.timeformat",
+		Usage:    "Go time layout for log timestamps (e.g. 2006-01-02T15:04:05.000Z07:00), format specific if unset",
+		EnvVars:  []string{"GETH_LOG_TIMEFORMAT"},
+		Category: flags.LoggingCategory,
+	}
+	logNoColorFlag = &cli.BoolFlag{
+		Name:     "log.nocolor",
+		Usage:    "Disable colored terminal log output, same as --log.color=never",
+		EnvVars:  []string{"GETH_LOG_NOCOLOR"},
+		Category: flags.LoggingCategory,
+	}
+	logColorFlag = &cli.StringFlag{
+		Name:     "log.color",
+		Usage:    "Colored terminal log output (auto, always or never), auto enables it on TTYs unless NO_COLOR is set",
+		EnvVars:  []string{"GETH_LOG_COLOR"},
+		Value:    "auto",
+		Category: flags.LoggingCategory,
+	}
+	logSplitFlag = &cli.BoolFlag{
+		Name:     "log.split",
+		Usage:    "Write error and critical messages to stderr and all others to stdout",
+		EnvVars:  []string{"GETH_LOG_SPLIT"},
+		Category: flags.LoggingCategory,
+	}
+	logFileFlag = &cli.StringFlag{
+		Name:     "log.file",
+		Usage:    "Write logs to a file, rotated by size (see --log.maxsize and --log.maxbackups)",
+		EnvVars:  []string{"GETH_LOG_FILE"},
+		Category: flags.LoggingCategory,
+	}
+	logFileOnlyFlag = &cli.BoolFlag{
+		Name:     "log.file.only",
+		Usage:    "Write logs only to the --log.file, not to stderr",
+		EnvVars:  []string{"GETH_LOG_FILE_ONLY"},
+		Category: flags.LoggingCategory,
+	}
+	logFileVerbosityFlag = &cli.IntFlag{
+		Name:     "log.file.verbosity",
+		Usage:    "Logging verbosity of the --log.file, independent of --verbosity: 0=silent, 1=error, 2=warn, 3=info, 4=debug, 5=detail",
+		EnvVars:  []string{"GETH_LOG_FILE_VERBOSITY"},
+		Value:    3,
+		Category: flags.LoggingCategory,
+	}
+	logMaxSizeFlag = &cli.IntFlag{
+		Name:     "log.maxsize",
+		Usage:    "Maximum size in megabytes of the log file before it gets rotated",
+		EnvVars:  []string{"GETH_LOG_MAXSIZE"},
+		Value:    100,
+		Category: flags.LoggingCategory,
+	}
+	logMaxBackupsFlag = &cli.IntFlag{
+		Name:     "log.maxbackups",
+		Usage:    "Maximum number of rotated log files to retain",
+		EnvVars:  []string{"GETH_LOG_MAXBACKUPS"},
+		Value:    10,
+		Category: flags.LoggingCategory,
+	}
+	logSyslogFlag = &cli.BoolFlag{
+		Name:     "log.syslog",
+		Usage:    "Additionally write logs to syslog",
+		EnvVars:  []string{"GETH_LOG_SYSLOG"},
+		Category: flags.LoggingCategory,
+	}
+	logSyslogNetworkFlag = &cli.StringFlag{
+		Name:     "log.syslog.network",
+		Usage:    "Network of the remote syslog daemon (e.g. udp, tcp)",
+		EnvVars:  []string{"GETH_LOG_SYSLOG_NETWORK"},
+		Value:    "udp",
+		Category: flags.LoggingCategory,
+	}
+	logSyslogAddrFlag = &cli.StringFlag{
+		Name:     "log.syslog.addr",
+		Usage:    "Address of the remote syslog daemon, local syslog is used if unset",
+		EnvVars:  []string{"GETH_LOG_SYSLOG_ADDR"},
+		Category: flags.LoggingCategory,
+	}
+	logJournaldFlag = &cli.BoolFlag{
+		Name:     "log.journald",
+		Usage:    "Also send logs to the systemd journal, with context stored as journal fields (Linux only)",
+		EnvVars:  []string{"GETH_LOG_JOURNALD"},
+		Category: flags.LoggingCategory,
+	}
+	logSampleRateFlag = &cli.DurationFlag{
+		Name:     "log.sample.rate",
+		Usage:    "Interval over which repeated log messages are sampled (0 = no sampling)",
+		EnvVars:  []string{"GETH_LOG_SAMPLE_RATE"},
+		Category: flags.LoggingCategory,
+	}
+	logSampleBurstFlag = &cli.IntFlag{
+		Name:     "log.sample.burst",
+		Usage:    "Number of identical log messages let through per --log.sample.rate interval",
+		EnvVars:  []string{"GETH_LOG_SAMPLE_BURST"},
+		Value:    10,
+		Category: flags.LoggingCategory,
+	}
+	logDedupWindowFlag = &cli.DurationFlag{
+		Name:     "log.dedup.window",
+		Usage:    "Suppress log records identical to one logged within this window, reporting the count afterwards (0 = disabled)",
+		EnvVars:  []string{"GETH_LOG_DEDUP_WINDOW"},
+		Category: flags.LoggingCategory,
+	}
+	logContextFlag = &cli.StringFlag{
+		Name:     "log.context",
+		Usage:    "Comma-separated list of key=value pairs attached to every log record (e.g. instance=node1,network=mainnet)",
+		EnvVars:  []string{"GETH_LOG_CONTEXT"},
 		Category: flags.LoggingCategory,
 	}
 	backtraceAtFlag = &cli.StringFlag{
 		Name:     "log.backtrace",
 		Usage:    "Request a stack trace at a specific logging statement (e.g. \"block.go:271\")",
+		EnvVars:  []string{"GETH_LOG_BACKTRACE"},
 		Value:    "",
 		Category: flags.LoggingCategory,
 	}
 	debugFlag = &cli.BoolFlag{
 		Name:     "log.debug",
 		Usage:    "Prepends log messages with call-site location (file and line number)",
+		EnvVars:  []string{"GETH_LOG_DEBUG"},
 		Category: flags.LoggingCategory,
 	}
 	pprofFlag = &cli.BoolFlag{
 		Name:     "pprof",
 		Usage:    "Enable the pprof HTTP server",
+		EnvVars:  []string{"GETH_PPROF"},
 		Category: flags.LoggingCategory,
 	}
 	pprofPortFlag = &cli.IntFlag{
 		Name:     "pprof.port",
 		Usage:    "pprof HTTP server listening port",
+		EnvVars:  []string{"GETH_PPROF_PORT"},
 		Value:    6060,
 		Category: flags.LoggingCategory,
 	}
 	pprofAddrFlag = &cli.StringFlag{
 		Name:     "pprof.addr",
-		Usage:    "pprof HTTP server listening interface",
+		Usage:    "pprof HTTP server listening interface (or unix:<path> for a Unix domain socket)",
+		EnvVars:  []string{"GETH_PPROF_ADDR"},
 		Value:    "127.0.0.1",
 		Category: flags.LoggingCategory,
 	}
+	pprofWithMetricsFlag = &cli.BoolFlag{
+		Name:     "pprof.withmetrics",
+		Usage:    "Serve go-metrics via expvar on the pprof server",
+		EnvVars:  []string{"GETH_PPROF_WITHMETRICS"},
+		Value:    true,
+		Category: flags.LoggingCategory,
+	}
+	pprofExpvarLazyFlag = &cli.BoolFlag{
+		Name:     "pprof.expvar.lazy",
+		Usage:    "Sync go-metrics into expvar in the background instead of on every /debug/metrics request",
+		EnvVars:  []string{"GETH_PPROF_EXPVAR_LAZY"},
+		Category: flags.LoggingCategory,
+	}
+	pprofStartDelayFlag = &cli.DurationFlag{
+		Name:     "pprof.startdelay",
+		Usage:    "Delay before the pprof HTTP server starts accepting connections (0 = start immediately)",
+		EnvVars:  []string{"GETH_PPROF_STARTDELAY"},
+		Category: flags.LoggingCategory,
+	}
+	pprofReadTimeoutFlag = &cli.DurationFlag{
+		Name:     "pprof.readtimeout",
+		Usage:    "Maximum time for clients of the pprof HTTP server to send the request headers (0 = no timeout)",
+		Value:    30 * time.Second,
+		EnvVars:  []string{"GETH_PPROF_READTIMEOUT"},
+		Category: flags.LoggingCategory,
+	}
+	pprofWriteTimeoutFlag = &cli.DurationFlag{
+		Name:     "pprof.writetimeout",
+		Usage:    "Maximum time for the pprof HTTP server to write a response, including profile collection (0 = no timeout)",
+		Value:    10 * time.Minute,
+		EnvVars:  []string{"GETH_PPROF_WRITETIMEOUT"},
+		Category: flags.LoggingCategory,
+	}
+	pprofRateLimitFlag = &cli.Float64Flag{
+		Name:     "pprof.ratelimit",
+		Usage:    "Maximum number of requests per second served by the pprof HTTP server (0 = unlimited)",
+		EnvVars:  []string{"GETH_PPROF_RATELIMIT"},
+		Category: flags.LoggingCategory,
+	}
+	pprofTLSCertFlag = &cli.StringFlag{
+		Name:     "pprof.tls.cert",
+		Usage:    "TLS certificate file for the pprof HTTP server (requires --pprof.tls.key)",
+		EnvVars:  []string{"GETH_PPROF_TLS_CERT"},
+		Category: flags.LoggingCategory,
+	}
+	pprofTLSKeyFlag = &cli.StringFlag{
+		Name:     "pprof.tls.key",
+		Usage:    "TLS private key file for the pprof HTTP server (requires --pprof.tls.cert)",
+		EnvVars:  []string{"GETH_PPROF_TLS_KEY"},
+		Category: flags.LoggingCategory,
+	}
+	pprofAuthUserFlag = &cli.StringFlag{
+		Name:     "pprof.auth.user",
+		Usage:    "Username required to access the pprof HTTP server (HTTP Basic auth)",
+		EnvVars:  []string{"GETH_PPROF_AUTH_USER"},
+		Category: flags.LoggingCategory,
+	}
+	pprofAuthPasswordFlag = &cli.StringFlag{
+		Name:     "pprof.auth.password",
+		Usage:    "Password required to access the pprof HTTP server (HTTP Basic auth)",
+		EnvVars:  []string{"GETH_PPROF_AUTH_PASSWORD"},
+		Category: flags.LoggingCategory,
+	}
+	pprofRedactFlag = &cli.StringFlag{
+		Name:     "pprof.cmdline.redact",
+		Usage:    "Comma-separated list of flags whose values are redacted from /debug/pprof/cmdline",
+		EnvVars:  []string{"GETH_PPROF_CMDLINE_REDACT"},
+		Value:    "pprof.auth.user,pprof.auth.password",
+		Category: flags.LoggingCategory,
+	}
+	pprofSharedMuxFlag = &cli.BoolFlag{
+		Name:     "pprof.shareddefaultmux",
+		Usage:    "Serve the pprof endpoints from http.DefaultServeMux, sharing it with other handlers registered there",
+		EnvVars:  []string{"GETH_PPROF_SHAREDDEFAULTMUX"},
+		Category: flags.LoggingCategory,
+	}
+	pprofMemsizePathFlag = &cli.StringFlag{
+		Name:     "pprof.memsize.path",
+		Usage:    "Path the memsize handler is served at on the pprof server (empty = disabled)",
+		EnvVars:  []string{"GETH_PPROF_MEMSIZE_PATH"},
+		Value:    "/memsize",
+		Category: flags.LoggingCategory,
+	}
 	memprofilerateFlag = &cli.IntFlag{
 		Name:     "pprof.memprofilerate",
-		Usage:    "Turn on memory profiling with the given rate",
+		Usage:    "Turn on memory profiling with the given rate (0 = disable memory profiling)",
+		EnvVars:  []string{"GETH_PPROF_MEMPROFILERATE"},
 		Value:    runtime.MemProfileRate,
 		Category: flags.LoggingCategory,
 	}
 	blockprofilerateFlag = &cli.IntFlag{
 		Name:     "pprof.blockprofilerate",
 		Usage:    "Turn on block profiling with the given rate",
+		EnvVars:  []string{"GETH_PPROF_BLOCKPROFILERATE"},
+		Category: flags.LoggingCategory,
+	}
+	mutexprofilefractionFlag = &cli.IntFlag{
+		Name:     "pprof.mutexprofilefraction",
+		Usage:    "Turn on mutex profiling, sampling on average 1/n of contention events",
+		EnvVars:  []string{"GETH_PPROF_MUTEXPROFILEFRACTION"},
+		Category: flags.LoggingCategory,
+	}
+	gcpercentFlag = &cli.IntFlag{
+		Name:     "gcpercent",
+		Usage:    "Set the garbage collection target percentage, overriding the cache based default (-1 = disable GC)",
+		EnvVars:  []string{"GETH_GCPERCENT"},
+		Category: flags.LoggingCategory,
+	}
+	memoryLimitFlag = &cli.StringFlag{
+		Name:     "memory.limit",
+		Usage:    "Soft memory limit for the Go runtime in bytes or with a unit suffix, e.g. 8GiB (0 = no limit)",
+		EnvVars:  []string{"GETH_MEMORY_LIMIT"},
 		Category: flags.LoggingCategory,
 	}
 	cpuprofileFlag = &cli.StringFlag{
 		Name:     "pprof.cpuprofile",
-		Usage:    "Write CPU profile to the given file",
+		Usage:    "Write CPU profile to the given file ({pid}, {timestamp} and {hostname} are expanded)",
+		EnvVars:  []string{"GETH_PPROF_CPUPROFILE"},
+		Category: flags.LoggingCategory,
+	}
+	cpuprofileDurationFlag = &cli.DurationFlag{
+		Name:     "pprof.cpuprofile.duration",
+		Usage:    "Stop the CPU profile after the given duration (0 = run until exit)",
+		EnvVars:  []string{"GETH_PPROF_CPUPROFILE_DURATION"},
+		Category: flags.LoggingCategory,
+	}
+	cpuprofileRateFlag = &cli.IntFlag{
+		Name:     "pprof.cpuprofile.rate",
+		Usage:    "CPU profiling sample rate in Hz (0 = runtime default of 100 Hz, custom rates make the runtime print a harmless warning)",
+		EnvVars:  []string{"GETH_PPROF_CPUPROFILE_RATE"},
 		Category: flags.LoggingCategory,
 	}
+	pprofLabelsFlag = &cli.StringFlag{
+		Name:     "pprof.labels",
+		Usage:    "Comma-separated key=value pprof labels attached to all goroutines, for filtering profiles",
+		EnvVars:  []string{"GETH_PPROF_LABELS"},
+		Category: flags.LoggingCategory,
+	}
+	pprofOverwriteFlag = &cli.BoolFlag{
+		Name:     "pprof.overwrite",
+		Usage:    "Allow --pprof.cpuprofile and --trace to overwrite existing files",
+		EnvVars:  []string{"GETH_PPROF_OVERWRITE"},
+		Category: flags.LoggingCategory,
+	}
+	pprofUploadURLFlag = &cli.StringFlag{
+		Name:     "pprof.upload.url",
+		Usage:    "Upload finished CPU profiles and heap dumps below this s3:// or http(s):// URL and remove the local files",
+		EnvVars:  []string{"GETH_PPROF_UPLOAD_URL"},
+		Category: flags.LoggingCategory,
+	}
+	heapdumpDirFlag = &cli.StringFlag{
+		Name:     "pprof.heapdump.dir",
+		Usage:    "Write a heap profile into the given directory on SIGUSR1 (and goroutine dumps on SIGUSR2)",
+		EnvVars:  []string{"GETH_PPROF_HEAPDUMP_DIR"},
+		Category: flags.LoggingCategory,
+	}
+	heapdumpThresholdFlag = &cli.Uint64Flag{
+		Name:     "pprof.heapdump.threshold",
+		Usage:    "Write a heap profile into --pprof.heapdump.dir when the heap grows beyond the given MiB (0 = disabled)",
+		EnvVars:  []string{"GETH_PPROF_HEAPDUMP_THRESHOLD"},
+		Category: flags.LoggingCategory,
+	}
+	snapshotSignalFlag = &cli.BoolFlag{
+		Name:     "pprof.snapshot.signal",
+		Usage:    "Write a snapshot of all profiles into --pprof.heapdump.dir on SIGUSR1 instead of a heap profile",
+		EnvVars:  []string{"GETH_PPROF_SNAPSHOT_SIGNAL"},
+		Category: flags.LoggingCategory,
+	}
+	dumpsKeepFlag = &cli.IntFlag{
+		Name:     "pprof.dumps.keep",
+		Usage:    "Maximum number of heap, goroutine and snapshot dumps of each kind kept in --pprof.heapdump.dir (0 = unlimited)",
+		EnvVars:  []string{"GETH_PPROF_DUMPS_KEEP"},
+		Category: flags.LoggingCategory,
+	}
+	goruntimeMetricsFlag = &cli.BoolFlag{
+		Name:     "metrics.goruntime",
+		Usage:    "Enable collection of Go runtime heap, stack and GC metrics (requires --metrics)",
+		EnvVars:  []string{"GETH_METRICS_GORUNTIME"},
+		Category: flags.MetricsCategory,
+	}
+	prometheusFlag = &cli.BoolFlag{
+		Name:     "metrics.prometheus",
+		Usage:    "Serve metrics in Prometheus format on the pprof server at /debug/metrics/prometheus",
+		EnvVars:  []string{"GETH_METRICS_PROMETHEUS"},
+		Category: flags.MetricsCategory,
+	}
 	traceFlag = &cli.StringFlag{
 		Name:     "trace",
-		Usage:    "Write execution trace to the given file",
+		Usage:    "Write execution trace to the given file ({pid}, {timestamp} and {hostname} are expanded)",
+		EnvVars:  []string{"GETH_TRACE"},
+		Category: flags.LoggingCategory,
+	}
+	traceRotateDurationFlag = &cli.DurationFlag{
+		Name:     "trace.rotate.duration",
+		Usage:    "Split the execution trace into numbered files of the given duration (0 = single file)",
+		EnvVars:  []string{"GETH_TRACE_ROTATE_DURATION"},
 		Category: flags.LoggingCategory,
 	}
 )
@@ -109,112 +432,1009 @@ var (
 var Flags = []cli.Flag{
 	verbosityFlag,
 	vmoduleFlag,
+	vmoduleFileFlag,
 	logjsonFlag,
+	logFormatFlag,
+	logJSONMaxFieldSizeFlag,
+	logTimeFormatFlag,
+	logNoColorFlag,
+	logColorFlag,
+	logSplitFlag,
+	logFileFlag,
+	logFileOnlyFlag,
+	logFileVerbosityFlag,
+	logMaxSizeFlag,
+	logMaxBackupsFlag,
+	logSyslogFlag,
+	logSyslogNetworkFlag,
+	logSyslogAddrFlag,
+	logJournaldFlag,
+	logSampleRateFlag,
+	logSampleBurstFlag,
+	logDedupWindowFlag,
+	logContextFlag,
 	backtraceAtFlag,
 	debugFlag,
 	pprofFlag,
 	pprofAddrFlag,
 	pprofPortFlag,
+	pprofWithMetricsFlag,
+	pprofExpvarLazyFlag,
+	pprofStartDelayFlag,
+	pprofReadTimeoutFlag,
+	pprofWriteTimeoutFlag,
+	pprofRateLimitFlag,
+	pprofTLSCertFlag,
+	pprofTLSKeyFlag,
+	pprofAuthUserFlag,
+	pprofAuthPasswordFlag,
+	pprofRedactFlag,
+	pprofSharedMuxFlag,
+	pprofMemsizePathFlag,
 	memprofilerateFlag,
 	blockprofilerateFlag,
+	mutexprofilefractionFlag,
+	gcpercentFlag,
+	memoryLimitFlag,
 	cpuprofileFlag,
+	cpuprofileDurationFlag,
+	cpuprofileRateFlag,
+	pprofLabelsFlag,
+	pprofOverwriteFlag,
+	pprofUploadURLFlag,
+	heapdumpDirFlag,
+	heapdumpThresholdFlag,
+	snapshotSignalFlag,
+	dumpsKeepFlag,
 	traceFlag,
+	traceRotateDurationFlag,
+	goruntimeMetricsFlag,
+	prometheusFlag,
 }
 
 var glogger *log.GlogHandler
 
+// pprofTLSCert and pprofTLSKey are the certificate and private key files used
+// by StartPProf to serve HTTPS. Both are empty if the server runs plain HTTP.
+var pprofTLSCert, pprofTLSKey string
+
+// pprofAuthUser and pprofAuthPassword are the HTTP Basic credentials required
+// by the pprof server. Authentication is disabled if no user is configured.
+var pprofAuthUser, pprofAuthPassword string
+
+// pprofRedacted is the set of flag names whose values are hidden from the
+// pprof cmdline endpoint.
+var pprofRedacted = map[string]bool{
+	pprofAuthUserFlag.Name:     true,
+	pprofAuthPasswordFlag.Name: true,
+}
+
+// pprofPrometheus is set if the pprof server should expose the Prometheus
+// endpoint even when go-metrics is not hooked into expvar.
+var pprofPrometheus bool
+
+// pprofExpvarLazy is set if go-metrics should be synced into expvar in the
+// background rather than on every /debug/metrics request.
+var pprofExpvarLazy bool
+
+// expvarRefreshInterval is the interval at which the lazy expvar handler syncs
+// the metrics registry.
+const expvarRefreshInterval = 3 * time.Second
+
+var (
+	lazyExpHandler     http.Handler // Shared lazy expvar handler, so only one sync loop runs
+	lazyExpHandlerOnce sync.Once
+)
+
+// runtimeMetricsOnce guards the start of the Go runtime metrics collector.
+var runtimeMetricsOnce sync.Once
+
+// pprofSharedMux is set if the pprof server should register its handlers on
+// http.DefaultServeMux and serve that, instead of using a private mux.
+var pprofSharedMux bool
+
+// pprofReadTimeout and pprofWriteTimeout bound the time the pprof server
+// waits for request headers and spends writing a response. Zero disables the
+// respective timeout.
+var (
+	pprofReadTimeout  = pprofReadTimeoutFlag.Value
+	pprofWriteTimeout = pprofWriteTimeoutFlag.Value
+)
+
+// pprofRateLimit is the maximum number of requests per second accepted by the
+// pprof server, 0 if unlimited.
+var pprofRateLimit float64
+
+// pprofMemsizePath is the path the memsize handler is mounted at on the pprof
+// server. The handler is not registered if it is empty.
+var pprofMemsizePath = pprofMemsizePathFlag.Value
+
+// pprofShutdownTimeout is the maximum time Exit waits for in-flight pprof
+// requests to finish before forcefully closing the server.
+const pprofShutdownTimeout = 5 * time.Second
+
+var (
+	pprofServer      *http.Server       // Currently running pprof server, nil if none
+	pprofListener    net.Listener       // Listener of the running pprof server
+	pprofStartTimer  *time.Timer        // Pending delayed pprof server start, nil if none
+	pprofStartCancel context.CancelFunc // Cancels the delayed start, even once the timer fired
+	pprofLock        sync.Mutex         // Lock protecting the pprof server and its settings
+)
+
+// sharedMuxOnce guards the registration on http.DefaultServeMux, which panics
+// on duplicate patterns once the shared mux pprof server is restarted.
+var sharedMuxOnce sync.Once
+
+// pprofSettings is a copy of the pprof server settings chosen at Setup, taken
+// when a server is started.
+type pprofSettings struct {
+	tlsCert, tlsKey           string
+	authUser, authPassword    string
+	rateLimit                 float64
+	readTimeout, writeTimeout time.Duration
+	sharedMux                 bool
+	prometheus, expvarLazy    bool
+	memsizePath               string
+}
+
+// currentPProfSettings returns the current pprof server settings. The caller
+// must hold pprofLock.
+func currentPProfSettings() pprofSettings {
+	return pprofSettings{
+		tlsCert:      pprofTLSCert,
+		tlsKey:       pprofTLSKey,
+		authUser:     pprofAuthUser,
+		authPassword: pprofAuthPassword,
+		rateLimit:    pprofRateLimit,
+		readTimeout:  pprofReadTimeout,
+		writeTimeout: pprofWriteTimeout,
+		sharedMux:    pprofSharedMux,
+		prometheus:   pprofPrometheus,
+		expvarLazy:   pprofExpvarLazy,
+		memsizePath:  pprofMemsizePath,
+	}
+}
+
 func init() {
 	glogger = log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
 	glogger.Verbosity(log.LvlInfo)
 	log.Root().SetHandler(glogger)
+
+	// Expose the logging configuration and sampling losses as metrics. Both
+	// gauges are evaluated on read, so runtime verbosity changes are included.
+	metrics.NewRegisteredFunctionalGauge("log/verbosity", nil, func() int64 {
+		return int64(glogger.GetVerbosity())
+	})
+	metrics.NewRegisteredFunctionalGauge("log/dropped", nil, func() int64 {
+		return int64(log.DroppedRecords())
+	})
+}
+
+// newLogFormat returns the log record formatter selected by --log.format.
+func newLogFormat(format string, usecolor bool) (log.Format, error) {
+	switch format {
+	case "terminal":
+		return log.TerminalFormat(usecolor), nil
+	case "json":
+		return log.JSONFormat(), nil
+	case "logfmt":
+		return log.LogfmtFormatRFC3339(), nil
+	case "gcp":
+		return log.GCPFormat(), nil
+	default:
+		return nil, errors.New("unknown log format, want terminal, json, logfmt or gcp")
+	}
+}
+
+// newConsoleHandler returns a handler writing records to the given terminal
+// stream. Colors are only used with the terminal format. In the "auto" color
+// mode, they are used if the stream is a TTY and NO_COLOR isn't set, while the
+// "always" and "never" modes skip the detection.
+func newConsoleHandler(f *os.File, logFormat string, color string) (log.Handler, error) {
+	output := io.Writer(f)
+	usecolor := false
+	if logFormat == "terminal" {
+		switch color {
+		case "always":
+			usecolor = true
+		case "auto":
+			usecolor = os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" &&
+				(isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd()))
+		}
+		if usecolor {
+			output = colorable.NewColorable(f)
+		}
+	}
+	format, err := newLogFormat(logFormat, usecolor)
+	if err != nil {
+		return nil, err
+	}
+	return log.StreamHandler(output, format), nil
+}
+
+// checkOverwrite returns an error if the output file of the given flag already
+// exists, unless overwriting was explicitly allowed with --pprof.overwrite.
+func checkOverwrite(flag, file string, overwrite bool) error {
+	if overwrite {
+		return nil
+	}
+	if _, err := os.Stat(expandHome(file)); err == nil {
+		return fmt.Errorf("--%s: %s already exists, set --%s to overwrite it", flag, file, pprofOverwriteFlag.Name)
+	}
+	return nil
+}
+
+// flagError annotates an error with the flag and the value that caused it, so
+// startup failures point the operator at the offending setting.
+func flagError(flag string, value interface{}, err error) error {
+	return fmt.Errorf("--%s %q: %w", flag, fmt.Sprint(value), err)
+}
+
+// readVmoduleFile reads a file of <pattern>=<level> entries, one or more per
+// line, ignoring blank lines and lines starting with #. The entries are
+// returned as a comma-separated vmodule ruleset.
+func readVmoduleFile(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	var rules []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			rules = append(rules, line)
+		}
+	}
+	return strings.Join(rules, ","), nil
+}
+
+// mergeVmodule merges the given vmodule rulesets, letting rules of later sets
+// override those of earlier ones. Rules for an identical pattern are replaced,
+// and since the glog handler applies the first matching rule, the remaining
+// rules are ordered latest first.
+func mergeVmodule(rulesets ...string) string {
+	var (
+		rules []string
+		index = make(map[string]int)
+	)
+	for _, ruleset := range rulesets {
+		for _, rule := range strings.Split(ruleset, ",") {
+			if rule = strings.TrimSpace(rule); rule == "" {
+				continue
+			}
+			pattern := strings.TrimSpace(strings.SplitN(rule, "=", 2)[0])
+			if i, ok := index[pattern]; ok {
+				rules[i] = ""
+			}
+			index[pattern] = len(rules)
+			rules = append(rules, rule)
+		}
+	}
+	merged := make([]string, 0, len(index))
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i] != "" {
+			merged = append(merged, rules[i])
+		}
+	}
+	return strings.Join(merged, ",")
+}
+
+// byteSizeUnits maps the unit suffixes accepted by parseByteSize to their
+// multipliers.
+var byteSizeUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// parseByteSize parses a number of bytes with an optional decimal (KB, MB, GB,
+// TB) or binary (KiB, MiB, GiB, TiB) unit suffix, such as "512MB" or "8GiB".
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	mult, ok := byteSizeUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in size %q", s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	size := n * mult
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q too large", s)
+	}
+	return int64(size), nil
+}
+
+// validateTimeFormat checks that layout is a Go time layout able to represent
+// timestamps, rejecting strings without any layout elements.
+func validateTimeFormat(layout string) error {
+	now := time.Now()
+	if formatted := now.Format(layout); formatted == layout {
+		return fmt.Errorf("invalid --%s %q: no time layout elements", logTimeFormatFlag.Name, layout)
+	} else if _, err := time.Parse(layout, formatted); err != nil {
+		return fmt.Errorf("invalid --%s %q: %v", logTimeFormatFlag.Name, layout, err)
+	}
+	return nil
+}
+
+// expandFileTemplate replaces the {pid}, {timestamp} and {hostname} placeholders
+// in a profile or trace file name.
+func expandFileTemplate(file string) string {
+	hostname, _ := os.Hostname()
+	return strings.NewReplacer(
+		"{pid}", strconv.Itoa(os.Getpid()),
+		"{timestamp}", time.Now().Format("2006-01-02T15-04-05"),
+		"{hostname}", hostname,
+	).Replace(file)
+}
+
+// parseLogContext parses a comma-separated list of key=value pairs into a log
+// context.
+func parseLogContext(s string) ([]interface{}, error) {
+	pairs, err := parseKeyValues(logContextFlag.Name, s)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]interface{}, len(pairs))
+	for i, field := range pairs {
+		fields[i] = field
+	}
+	return fields, nil
+}
+
+// parseKeyValues parses the comma-separated list of key=value pairs given to
+// the named flag into a flat list of keys and values.
+func parseKeyValues(flag, s string) ([]string, error) {
+	var pairs []string
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid --%s entry %q, want key=value", flag, pair)
+		}
+		pairs = append(pairs, strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	return pairs, nil
+}
+
+// GCPercentSet reports whether the garbage collection target percentage was
+// set explicitly with --gcpercent, in which case Setup applies it.
+func GCPercentSet(ctx *cli.Context) bool {
+	return ctx.IsSet(gcpercentFlag.Name)
 }
 
 // Setup initializes profiling and logging based on the CLI flags.
 // It should be called as early as possible in the program.
 func Setup(ctx *cli.Context) error {
-	var ostream log.Handler
-	output := io.Writer(os.Stderr)
-	if ctx.Bool(logjsonFlag.Name) {
-		ostream = log.StreamHandler(output, log.JSONFormat())
-	} else {
-		usecolor := (isatty.IsTerminal(os.Stderr.Fd()) || isatty.IsCygwinTerminal(os.Stderr.Fd())) && os.Getenv("TERM") != "dumb"
-		if usecolor {
-			output = colorable.NewColorableStderr()
+	cfg := Config{
+		Verbosity:        ctx.Int(verbosityFlag.Name),
+		Vmodule:          ctx.String(vmoduleFlag.Name),
+		VmoduleFiles:     ctx.StringSlice(vmoduleFileFlag.Name),
+		LogFormat:        ctx.String(logFormatFlag.Name),
+		LogJSONFieldMax:  ctx.Int(logJSONMaxFieldSizeFlag.Name),
+		LogTimeFormat:    ctx.String(logTimeFormatFlag.Name),
+		LogJSON:          ctx.Bool(logjsonFlag.Name),
+		LogNoColor:       ctx.Bool(logNoColorFlag.Name),
+		LogColor:         ctx.String(logColorFlag.Name),
+		LogSplit:         ctx.Bool(logSplitFlag.Name),
+		LogFile:          ctx.String(logFileFlag.Name),
+		LogFileOnly:      ctx.Bool(logFileOnlyFlag.Name),
+		LogMaxSize:       ctx.Int(logMaxSizeFlag.Name),
+		LogMaxBackups:    ctx.Int(logMaxBackupsFlag.Name),
+		LogSyslog:        ctx.Bool(logSyslogFlag.Name),
+		LogSyslogNetwork: ctx.String(logSyslogNetworkFlag.Name),
+		LogSyslogAddr:    ctx.String(logSyslogAddrFlag.Name),
+		LogJournald:      ctx.Bool(logJournaldFlag.Name),
+		LogSampleRate:    ctx.Duration(logSampleRateFlag.Name),
+		LogSampleBurst:   ctx.Int(logSampleBurstFlag.Name),
+		LogDedupWindow:   ctx.Duration(logDedupWindowFlag.Name),
+		LogContext:       ctx.String(logContextFlag.Name),
+		Debug:            ctx.Bool(debugFlag.Name),
+		BacktraceAt:      ctx.String(backtraceAtFlag.Name),
+
+		MemProfileRate:       ctx.Int(memprofilerateFlag.Name),
+		BlockProfileRate:     ctx.Int(blockprofilerateFlag.Name),
+		MutexProfileFraction: ctx.Int(mutexprofilefractionFlag.Name),
+		CPUProfile:           ctx.String(cpuprofileFlag.Name),
+		CPUProfileDuration:   ctx.Duration(cpuprofileDurationFlag.Name),
+		CPUProfileRate:       ctx.Int(cpuprofileRateFlag.Name),
+		ProfileLabels:        ctx.String(pprofLabelsFlag.Name),
+		ProfileOverwrite:     ctx.Bool(pprofOverwriteFlag.Name),
+		ProfileUploadURL:     ctx.String(pprofUploadURLFlag.Name),
+		Trace:                ctx.String(traceFlag.Name),
+		TraceRotateDuration:  ctx.Duration(traceRotateDurationFlag.Name),
+		HeapDumpDir:          ctx.String(heapdumpDirFlag.Name),
+		HeapDumpThreshold:    ctx.Uint64(heapdumpThresholdFlag.Name),
+		SnapshotOnSignal:     ctx.Bool(snapshotSignalFlag.Name),
+		DumpsKeep:            ctx.Int(dumpsKeepFlag.Name),
+		GoRuntimeMetrics:     ctx.Bool(goruntimeMetricsFlag.Name),
+
+		Pprof:             ctx.Bool(pprofFlag.Name),
+		PprofWithMetrics:  ctx.Bool(pprofWithMetricsFlag.Name),
+		PprofAddr:         ctx.String(pprofAddrFlag.Name),
+		PprofPort:         ctx.Int(pprofPortFlag.Name),
+		PprofPrometheus:   ctx.Bool(prometheusFlag.Name),
+		PprofExpvarLazy:   ctx.Bool(pprofExpvarLazyFlag.Name),
+		PprofStartDelay:   ctx.Duration(pprofStartDelayFlag.Name),
+		PprofReadTimeout:  ctx.Duration(pprofReadTimeoutFlag.Name),
+		PprofWriteTimeout: ctx.Duration(pprofWriteTimeoutFlag.Name),
+		PprofRateLimit:    ctx.Float64(pprofRateLimitFlag.Name),
+		PprofTLSCert:      ctx.String(pprofTLSCertFlag.Name),
+		PprofTLSKey:       ctx.String(pprofTLSKeyFlag.Name),
+		PprofAuthUser:     ctx.String(pprofAuthUserFlag.Name),
+		PprofAuthPassword: ctx.String(pprofAuthPasswordFlag.Name),
+		PprofRedact:       strings.Split(ctx.String(pprofRedactFlag.Name), ","),
+		PprofSharedMux:    ctx.Bool(pprofSharedMuxFlag.Name),
+		PprofMemsizePath:  ctx.String(pprofMemsizePathFlag.Name),
+	}
+	if ctx.IsSet(logFileVerbosityFlag.Name) {
+		verbosity := ctx.Int(logFileVerbosityFlag.Name)
+		cfg.LogFileVerbosity = &verbosity
+	}
+	if limit := ctx.String(memoryLimitFlag.Name); limit != "" {
+		size, err := parseByteSize(limit)
+		if err != nil {
+			return flagError(memoryLimitFlag.Name, limit, err)
 		}
-		ostream = log.StreamHandler(output, log.TerminalFormat(usecolor))
+		cfg.MemoryLimit = size
+	}
+	if ctx.IsSet(gcpercentFlag.Name) {
+		percent := ctx.Int(gcpercentFlag.Name)
+		cfg.GCPercent = &percent
+	}
+	return SetupWith(cfg)
+}
+
+// SetupWith initializes profiling and logging based on the given configuration,
+// allowing programs embedding geth to do so without the CLI flags.
+// It should be called as early as possible in the program.
+//
+// Callers should start from DefaultConfig and override the settings they need:
+// a zero value is not always "unset", e.g. a zero MemProfileRate turns memory
+// profiling off and zero pprof server timeouts disable them.
+func SetupWith(cfg Config) error {
+	logFormat := cfg.LogFormat
+	if logFormat == "" {
+		logFormat = "terminal"
+		if cfg.LogJSON {
+			logFormat = "json"
+		}
+	}
+	color := cfg.LogColor
+	switch color {
+	case "":
+		color = "auto"
+	case "auto", "always", "never":
+	default:
+		return flagError(logColorFlag.Name, color, errors.New("want auto, always or never"))
+	}
+	if cfg.LogNoColor {
+		if color == "always" {
+			return fmt.Errorf("--%s conflicts with --%s=always", logNoColorFlag.Name, logColorFlag.Name)
+		}
+		color = "never"
+	}
+	ostream, err := newConsoleHandler(os.Stderr, logFormat, color)
+	if err != nil {
+		return flagError(logFormatFlag.Name, logFormat, err)
+	}
+	if cfg.LogSplit {
+		// Errors and above stay on stderr, everything else goes to stdout.
+		stdout, err := newConsoleHandler(os.Stdout, logFormat, color)
+		if err != nil {
+			return flagError(logFormatFlag.Name, logFormat, err)
+		}
+		ostream = log.MultiHandler(
+			log.LvlFilterHandler(log.LvlError, ostream),
+			log.FilterHandler(func(r *log.Record) bool { return r.Lvl > log.LvlError }, stdout),
+		)
+	}
+	if cfg.LogTimeFormat != "" {
+		if err := validateTimeFormat(cfg.LogTimeFormat); err != nil {
+			return err
+		}
+	}
+	log.SetTimeFormat(cfg.LogTimeFormat)
+	logFormatName = logFormat
+	log.SetJSONMaxFieldSize(cfg.LogJSONFieldMax)
+
+	if cfg.LogFileVerbosity != nil && *cfg.LogFileVerbosity < 0 {
+		return flagError(logFileVerbosityFlag.Name, *cfg.LogFileVerbosity, errors.New("must not be negative"))
+	}
+	var (
+		fileGlogger *log.GlogHandler
+		rotating    *rotatingFile
+	)
+	if cfg.LogFile != "" {
+		var err error
+		if rotating, err = newRotatingFile(expandHome(cfg.LogFile), cfg.LogMaxSize, cfg.LogMaxBackups); err != nil {
+			return flagError(logFileFlag.Name, cfg.LogFile, err)
+		}
+		// Don't leak the file if the setup fails before the handlers using it
+		// are installed.
+		defer func() {
+			if logFile != rotating {
+				rotating.Close()
+			}
+		}()
+		format, _ := newLogFormat(logFormat, false)
+		filestream := log.StreamHandler(rotating, format)
+
+		switch {
+		case cfg.LogFileOnly:
+			ostream = filestream
+		case cfg.LogFileVerbosity != nil:
+			fileGlogger = log.NewGlogHandler(filestream)
+		default:
+			ostream = log.MultiHandler(ostream, filestream)
+		}
+	} else if cfg.LogFileOnly {
+		return fmt.Errorf("--%s requires --%s", logFileOnlyFlag.Name, logFileFlag.Name)
+	}
+	if cfg.LogSyslog {
+		format, _ := newLogFormat(logFormat, false)
+		syslog, err := newSyslogHandler(cfg.LogSyslogNetwork, cfg.LogSyslogAddr, format)
+		if err != nil {
+			return fmt.Errorf("--%s: %v", logSyslogFlag.Name, err)
+		}
+		ostream = log.MultiHandler(ostream, syslog)
+	}
+	if cfg.LogJournald {
+		journald, err := newJournaldHandler()
+		if err != nil {
+			return fmt.Errorf("--%s: %v", logJournaldFlag.Name, err)
+		}
+		ostream = log.MultiHandler(ostream, journald)
+	}
+	if cfg.LogSampleRate > 0 {
+		ostream = log.SamplingHandler(cfg.LogSampleRate, cfg.LogSampleBurst, ostream)
+	}
+	if cfg.LogDedupWindow > 0 {
+		ostream = log.DedupHandler(cfg.LogDedupWindow, ostream)
 	}
-	glogger.SetHandler(ostream)
 
 	// logging
-	verbosity := ctx.Int(verbosityFlag.Name)
+	vmodule := cfg.Vmodule
+	if len(cfg.VmoduleFiles) > 0 {
+		rulesets := make([]string, 0, len(cfg.VmoduleFiles)+1)
+		for _, file := range cfg.VmoduleFiles {
+			ruleset, err := readVmoduleFile(expandHome(file))
+			if err != nil {
+				return flagError(vmoduleFileFlag.Name, file, err)
+			}
+			rulesets = append(rulesets, ruleset)
+		}
+		vmodule = mergeVmodule(append(rulesets, cfg.Vmodule)...)
+	}
+	verbosity := cfg.Verbosity
+	if cfg.LogFileOnly && cfg.LogFileVerbosity != nil {
+		verbosity = *cfg.LogFileVerbosity
+	}
 	glogger.Verbosity(log.Lvl(verbosity))
-	vmodule := ctx.String(vmoduleFlag.Name)
 	glogger.Vmodule(vmodule)
 
-	debug := ctx.Bool(debugFlag.Name)
-	if ctx.IsSet(debugFlag.Name) {
-		debug = ctx.Bool(debugFlag.Name)
+	log.PrintOrigins(cfg.Debug)
+
+	glogger.BacktraceAt(cfg.BacktraceAt)
+
+	var root log.Handler = glogger
+	if fileGlogger != nil {
+		fileGlogger.Verbosity(log.Lvl(*cfg.LogFileVerbosity))
+		fileGlogger.Vmodule(vmodule)
+		root = log.MultiHandler(glogger, fileGlogger)
+	}
+	if cfg.LogContext != "" {
+		// Attach the fields in a handler rather than a child logger, so that the
+		// package level logging functions and existing loggers carry them too.
+		fields, err := parseLogContext(cfg.LogContext)
+		if err != nil {
+			return err
+		}
+		next := root
+		root = log.FuncHandler(func(r *log.Record) error {
+			r.Ctx = append(r.Ctx, fields...)
+			return next.Log(r)
+		})
 	}
-	log.PrintOrigins(debug)
+	glogger.SetHandler(ostream)
+	log.Root().SetHandler(root)
 
-	backtrace := ctx.String(backtraceAtFlag.Name)
-	glogger.BacktraceAt(backtrace)
+	// The handlers of a previous setup are replaced, so its log file can go.
+	closeLogFile()
+	logFile = rotating
 
-	log.Root().SetHandler(glogger)
+	// profiling, tracing. An explicit memory profiling rate of 0 is honored,
+	// turning allocation profiling off.
+	runtime.MemProfileRate = cfg.MemProfileRate
+
+	Handler.SetBlockProfileRate(cfg.BlockProfileRate)
+	Handler.SetMutexProfileFraction(cfg.MutexProfileFraction)
+
+	if cfg.GCPercent != nil {
+		if *cfg.GCPercent < -1 {
+			return flagError(gcpercentFlag.Name, *cfg.GCPercent, errors.New("must be -1 (GC disabled) or non-negative"))
+		}
+		Handler.SetGCPercent(*cfg.GCPercent)
+		log.Info("Set garbage collection target", "percent", *cfg.GCPercent)
+	}
+
+	if cfg.MemoryLimit < 0 {
+		return flagError(memoryLimitFlag.Name, cfg.MemoryLimit, errors.New("must not be negative"))
+	}
+	if cfg.MemoryLimit > 0 {
+		if err := setMemoryLimit(cfg.MemoryLimit); err != nil {
+			return flagError(memoryLimitFlag.Name, cfg.MemoryLimit, err)
+		}
+		log.Info("Set soft memory limit", "bytes", cfg.MemoryLimit)
+	}
+
+	if cfg.ProfileUploadURL != "" {
+		if err := validateUploadURL(cfg.ProfileUploadURL); err != nil {
+			return flagError(pprofUploadURLFlag.Name, cfg.ProfileUploadURL, err)
+		}
+	}
+	pprofUploadURL = cfg.ProfileUploadURL
 
-	// profiling, tracing
-	runtime.MemProfileRate = memprofilerateFlag.Value
-	if ctx.IsSet(memprofilerateFlag.Name) {
-		runtime.MemProfileRate = ctx.Int(memprofilerateFlag.Name)
+	if cfg.CPUProfileRate < 0 || cfg.CPUProfileRate > maxCPUProfileRate {
+		return flagError(cpuprofileRateFlag.Name, cfg.CPUProfileRate, fmt.Errorf("must be between 0 and %d", maxCPUProfileRate))
 	}
+	cpuProfileRate = cfg.CPUProfileRate
 
-	blockProfileRate := ctx.Int(blockprofilerateFlag.Name)
-	Handler.SetBlockProfileRate(blockProfileRate)
+	if cfg.ProfileLabels != "" {
+		labels, err := parseKeyValues(pprofLabelsFlag.Name, cfg.ProfileLabels)
+		if err != nil {
+			return err
+		}
+		pprofx.SetLabels(labels...)
+	}
 
-	if traceFile := ctx.String(traceFlag.Name); traceFile != "" {
-		if err := Handler.StartGoTrace(traceFile); err != nil {
+	if cfg.TraceRotateDuration < 0 {
+		return flagError(traceRotateDurationFlag.Name, cfg.TraceRotateDuration, errors.New("must not be negative"))
+	}
+	if cfg.TraceRotateDuration > 0 && cfg.Trace == "" {
+		return fmt.Errorf("--%s requires --%s", traceRotateDurationFlag.Name, traceFlag.Name)
+	}
+	if cfg.Trace != "" {
+		file := expandFileTemplate(cfg.Trace)
+		first := file
+		if cfg.TraceRotateDuration > 0 {
+			first = traceSegmentFile(file, 1)
+		}
+		if err := checkOverwrite(traceFlag.Name, first, cfg.ProfileOverwrite); err != nil {
 			return err
 		}
+		if err := Handler.StartGoTrace(first); err != nil {
+			return flagError(traceFlag.Name, first, err)
+		}
+		if cfg.TraceRotateDuration > 0 {
+			startTraceRotation(file, cfg.TraceRotateDuration)
+		}
 	}
 
-	if cpuFile := ctx.String(cpuprofileFlag.Name); cpuFile != "" {
-		if err := Handler.StartCPUProfile(cpuFile); err != nil {
+	if cfg.CPUProfile != "" {
+		file := expandFileTemplate(cfg.CPUProfile)
+		if err := checkOverwrite(cpuprofileFlag.Name, file, cfg.ProfileOverwrite); err != nil {
 			return err
 		}
+		if err := Handler.StartCPUProfile(file); err != nil {
+			return flagError(cpuprofileFlag.Name, file, err)
+		}
+		if duration := cfg.CPUProfileDuration; duration > 0 {
+			time.AfterFunc(duration, func() {
+				if err := Handler.StopCPUProfile(); err == nil {
+					log.Info("CPU profile duration elapsed", "duration", duration)
+				}
+			})
+		}
+	}
+
+	if cfg.DumpsKeep < 0 {
+		return flagError(dumpsKeepFlag.Name, cfg.DumpsKeep, errors.New("must not be negative"))
+	}
+	if cfg.DumpsKeep > 0 && cfg.HeapDumpDir == "" {
+		return fmt.Errorf("--%s requires --%s", dumpsKeepFlag.Name, heapdumpDirFlag.Name)
+	}
+	dumpsKeep = cfg.DumpsKeep
+
+	// Replace the dump handlers and heap watcher of a previous setup.
+	removeDumpHandlers()
+	stopHeapWatch()
+
+	dumpDir := cfg.HeapDumpDir
+	if dumpDir != "" {
+		dumpDir = expandHome(dumpDir)
+		installHeapDumpHandler(dumpDir, cfg.SnapshotOnSignal)
+	} else if cfg.SnapshotOnSignal {
+		return fmt.Errorf("--%s requires --%s", snapshotSignalFlag.Name, heapdumpDirFlag.Name)
+	}
+	installStackDumpHandler(dumpDir)
+	snapshotDir = dumpDir
+
+	if cfg.HeapDumpThreshold > 0 {
+		if dumpDir == "" {
+			return fmt.Errorf("--%s requires --%s", heapdumpThresholdFlag.Name, heapdumpDirFlag.Name)
+		}
+		startHeapWatch(dumpDir, cfg.HeapDumpThreshold*1024*1024)
+	}
+
+	if cfg.GoRuntimeMetrics {
+		// The collector never stops, so repeated setups must not stack them.
+		runtimeMetricsOnce.Do(func() {
+			go metrics.CollectRuntimeMetrics(3 * time.Second)
+		})
+	}
+
+	// A half configured TLS pair is rejected even if the pprof server is off.
+	if (cfg.PprofTLSCert == "") != (cfg.PprofTLSKey == "") {
+		return fmt.Errorf("--%s and --%s must be set together", pprofTLSCertFlag.Name, pprofTLSKeyFlag.Name)
 	}
 
 	// pprof server
-	if ctx.Bool(pprofFlag.Name) {
-		listenHost := ctx.String(pprofAddrFlag.Name)
+	if cfg.Pprof {
+		address := fmt.Sprintf("%s:%d", cfg.PprofAddr, cfg.PprofPort)
+		if strings.HasPrefix(cfg.PprofAddr, "unix:") {
+			address = cfg.PprofAddr
+		}
+
+		if cfg.PprofAuthPassword != "" && cfg.PprofAuthUser == "" {
+			return fmt.Errorf("--%s requires --%s", pprofAuthPasswordFlag.Name, pprofAuthUserFlag.Name)
+		}
+		if cfg.PprofMemsizePath != "" && !strings.HasPrefix(cfg.PprofMemsizePath, "/") {
+			return flagError(pprofMemsizePathFlag.Name, cfg.PprofMemsizePath, errors.New("must start with /"))
+		}
+		if cfg.PprofRateLimit < 0 {
+			return flagError(pprofRateLimitFlag.Name, cfg.PprofRateLimit, errors.New("must not be negative"))
+		}
+		pprofLock.Lock()
+		pprofTLSCert, pprofTLSKey = cfg.PprofTLSCert, cfg.PprofTLSKey
+		pprofAuthUser, pprofAuthPassword = cfg.PprofAuthUser, cfg.PprofAuthPassword
+		pprofPrometheus = cfg.PprofPrometheus
+		pprofExpvarLazy = cfg.PprofExpvarLazy
+		pprofSharedMux = cfg.PprofSharedMux
+		pprofMemsizePath = cfg.PprofMemsizePath
+		pprofRateLimit = cfg.PprofRateLimit
+		pprofReadTimeout, pprofWriteTimeout = cfg.PprofReadTimeout, cfg.PprofWriteTimeout
+		settings := currentPProfSettings()
+		pprofLock.Unlock()
 
-		port := ctx.Int(pprofPortFlag.Name)
+		pprofRedacted = make(map[string]bool)
+		for _, name := range cfg.PprofRedact {
+			if name = strings.TrimLeft(strings.TrimSpace(name), "-"); name != "" {
+				pprofRedacted[name] = true
+			}
+		}
+		if delay := cfg.PprofStartDelay; delay > 0 {
+			// Listener errors can't be returned anymore once delayed, log them.
+			log.Info("Delaying pprof server start", "delay", delay)
+			ctx, cancel := context.WithCancel(context.Background())
 
-		address := fmt.Sprintf("%s:%d", listenHost, port)
-		// This context value ("metrics.addr") represents the utils.MetricsHTTPFlag.Name.
-		// It cannot be imported because it will cause a cyclical dependency.
-		StartPProf(address, !ctx.IsSet("metrics.addr"))
+			pprofLock.Lock()
+			if pprofStartTimer != nil {
+				pprofStartTimer.Stop()
+				pprofStartCancel()
+			}
+			withMetrics := cfg.PprofWithMetrics
+			pprofStartTimer = time.AfterFunc(delay, func() {
+				if ctx.Err() != nil {
+					return
+				}
+				// Should Exit race with the start, the canceled context shuts
+				// the server down again.
+				if err := startPProf(ctx, address, withMetrics, settings); err != nil {
+					log.Error("Failed to start pprof server", "err", err)
+				}
+			})
+			pprofStartCancel = cancel
+			pprofLock.Unlock()
+			return nil
+		}
+		if err := startPProf(context.Background(), address, cfg.PprofWithMetrics, settings); err != nil {
+			return flagError(pprofAddrFlag.Name, address, err)
+		}
 	}
 	return nil
 }
 
-func StartPProf(address string, withMetrics bool) {
-	// Hook go-metrics into expvar on any /debug/metrics request, load all vars
-	// from the registry into expvar, and execute regular expvar handler.
-	if withMetrics {
-		exp.Exp(metrics.DefaultRegistry)
+// StartPProf starts the pprof server on the given address, replacing the one
+// started before, if any. The listener is opened synchronously, so an error is
+// returned if the address is unavailable.
+//
+// Unless --pprof.shareddefaultmux is set, the server uses a private mux and
+// none of its endpoints are added to http.DefaultServeMux. The one exception is
+// /debug/vars, which the expvar package registers there on its own; go-metrics
+// are exported through expvar, so it stays linked in.
+func StartPProf(address string, withMetrics bool) error {
+	return StartPProfContext(context.Background(), address, withMetrics)
+}
+
+// StartPProfContext is like StartPProf, but shuts the server down once ctx is
+// canceled, allowing embedders to tie its lifetime to their own.
+func StartPProfContext(ctx context.Context, address string, withMetrics bool) error {
+	pprofLock.Lock()
+	settings := currentPProfSettings()
+	pprofLock.Unlock()
+
+	return startPProf(ctx, address, withMetrics, settings)
+}
+
+// startPProf starts the pprof server with the given settings, shutting down a
+// server started before, so its listener is released first.
+func startPProf(ctx context.Context, address string, withMetrics bool, s pprofSettings) error {
+	pprofLock.Lock()
+	defer pprofLock.Unlock()
+
+	if pprofServer != nil {
+		shutdownPProf(pprofServer, pprofListener)
+		pprofServer, pprofListener = nil, nil
+	}
+	listener, err := pprofListen(address)
+	if err != nil {
+		return err
+	}
+	var handler http.Handler
+	if s.sharedMux {
+		sharedMuxOnce.Do(func() {
+			registerProfileHandlers(http.DefaultServeMux)
+			registerPProfHandlers(http.DefaultServeMux, withMetrics, s)
+		})
+		handler = http.DefaultServeMux
+	} else {
+		mux := http.NewServeMux()
+		registerProfileHandlers(mux)
+		mux.Handle("/debug/vars", expvar.Handler())
+		registerPProfHandlers(mux, withMetrics, s)
+		handler = mux
+	}
+	scheme := "http"
+	if s.tlsCert != "" {
+		scheme = "https"
+	}
+	if strings.HasPrefix(address, "unix:") {
+		log.Info("Starting pprof server", "addr", address, "scheme", scheme)
+	} else {
+		log.Info("Starting pprof server", "addr", fmt.Sprintf("%s://%s/debug/pprof", scheme, address))
+	}
+	if s.authUser != "" {
+		handler = basicAuthHandler(s.authUser, s.authPassword, handler)
+	}
+	if s.rateLimit > 0 {
+		handler = rateLimitHandler(s.rateLimit, handler)
 	}
-	http.Handle("/memsize/", http.StripPrefix("/memsize", &Memsize))
-	log.Info("Starting pprof server", "addr", fmt.Sprintf("http://%s/debug/pprof", address))
+	server := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: s.readTimeout,
+		WriteTimeout:      s.writeTimeout,
+	}
+	pprofServer, pprofListener = server, listener
+
 	go func() {
-		if err := http.ListenAndServe(address, nil); err != nil {
+		var err error
+		if s.tlsCert != "" {
+			err = server.ServeTLS(listener, s.tlsCert, s.tlsKey)
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Error("Failure in running pprof server", "err", err)
 		}
 	}()
+	if ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+
+			pprofLock.Lock()
+			if pprofServer == server {
+				pprofServer, pprofListener = nil, nil
+			}
+			pprofLock.Unlock()
+			shutdownPProf(server, listener)
+		}()
+	}
+	return nil
+}
+
+// registerPProfHandlers adds the metrics, memsize and runtime debug endpoints
+// served next to the pprof handlers to the given mux.
+func registerPProfHandlers(mux *http.ServeMux, withMetrics bool, s pprofSettings) {
+	// Hook go-metrics into expvar on any /debug/metrics request, load all vars
+	// from the registry into expvar, and execute regular expvar handler.
+	if withMetrics {
+		if s.expvarLazy {
+			lazyExpHandlerOnce.Do(func() {
+				lazyExpHandler = exp.ExpHandlerLazy(metrics.DefaultRegistry, expvarRefreshInterval)
+			})
+			mux.Handle("/debug/metrics", lazyExpHandler)
+		} else {
+			mux.Handle("/debug/metrics", exp.ExpHandler(metrics.DefaultRegistry))
+		}
+	}
+	if withMetrics || s.prometheus {
+		mux.Handle("/debug/metrics/prometheus", prometheus.Handler(metrics.DefaultRegistry))
+	}
+	if prefix := strings.TrimSuffix(s.memsizePath, "/"); s.memsizePath != "" {
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, &Memsize))
+	}
+	mux.HandleFunc("/debug/verbosity", verbosityHandler)
+	mux.HandleFunc("/debug/vmodule", vmoduleHandler)
+	mux.HandleFunc("/debug/logconfig", logConfigHandler)
+	mux.HandleFunc("/debug/trace", traceHandler)
+	mux.HandleFunc("/debug/healthz", healthzHandler)
+	mux.HandleFunc("/debug/gc", gcHandler)
+	mux.HandleFunc("/debug/pprof/profile-custom", profileCustomHandler)
+	mux.HandleFunc("/debug/pprof/snapshot", snapshotHandler)
+}
+
+// pprofListen opens the listener for the pprof server. Addresses of the form
+// unix:<path> are served from a Unix domain socket accessible only to the owner,
+// which is removed again when the server is shut down.
+func pprofListen(address string) (net.Listener, error) {
+	path := strings.TrimPrefix(address, "unix:")
+	if path == address {
+		return net.Listen("tcp", address)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// stopPProf gracefully shuts down the pprof server, if one is running, and
+// cancels a delayed server start that is still pending.
+func stopPProf() {
+	pprofLock.Lock()
+	server, listener := pprofServer, pprofListener
+	pprofServer, pprofListener = nil, nil
+	if pprofStartTimer != nil {
+		pprofStartTimer.Stop()
+		pprofStartCancel()
+		pprofStartTimer, pprofStartCancel = nil, nil
+	}
+	pprofLock.Unlock()
+
+	if server != nil {
+		shutdownPProf(server, listener)
+	}
+}
+
+// shutdownPProf gracefully shuts down the given pprof server, waiting at most
+// pprofShutdownTimeout for in-flight requests to finish. The listener is closed
+// explicitly, as the server only tracks it once its Serve goroutine is running.
+func shutdownPProf(server *http.Server, listener net.Listener) {
+	ctx, cancel := context.WithTimeout(context.Background(), pprofShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Warn("Failed to shut down pprof server", "err", err)
+	}
+	listener.Close()
 }
 
 // Exit stops all running profiles, flushing their output to the
-// respective file.
+// respective file, resets the block and mutex profiling rates, removes the dump
+// signal handlers, shuts down the pprof server and closes the log file.
 func Exit() {
 	Handler.StopCPUProfile()
+	stopTraceRotation()
 	Handler.StopGoTrace()
+	Handler.SetBlockProfileRate(0)
+	Handler.SetMutexProfileFraction(0)
+	removeDumpHandlers()
+	stopHeapWatch()
+	stopPProf()
+	closeLogFile()
 }
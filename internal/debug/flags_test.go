@@ -0,0 +1,629 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debug
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/urfave/cli/v2"
+)
+
+// runSetup runs Setup with the debug flags parsed from the given arguments.
+func runSetup(t *testing.T, args ...string) {
+	t.Helper()
+
+	app := cli.NewApp()
+	app.Flags = Flags
+	app.Action = func(ctx *cli.Context) error {
+		return Setup(ctx)
+	}
+	if err := app.Run(append([]string{"test"}, args...)); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+}
+
+func TestSetupMemProfileRate(t *testing.T) {
+	defer func(rate int) { runtime.MemProfileRate = rate }(runtime.MemProfileRate)
+
+	runSetup(t, "--pprof.memprofilerate=0")
+	if runtime.MemProfileRate != 0 {
+		t.Fatalf("memory profile rate mismatch: have %d, want 0", runtime.MemProfileRate)
+	}
+	runSetup(t)
+	if runtime.MemProfileRate != memprofilerateFlag.Value {
+		t.Fatalf("memory profile rate mismatch: have %d, want %d", runtime.MemProfileRate, memprofilerateFlag.Value)
+	}
+}
+
+func TestSetupLogSplit(t *testing.T) {
+	dir := t.TempDir()
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+
+	defer func(stdout, stderr *os.File) {
+		os.Stdout, os.Stderr = stdout, stderr
+		runSetup(t)
+	}(os.Stdout, os.Stderr)
+	os.Stdout, os.Stderr = stdout, stderr
+
+	runSetup(t, "--log.split", "--log.format=logfmt")
+	log.Error("split error")
+	log.Warn("split warning")
+	log.Info("split info")
+
+	outData, _ := os.ReadFile(stdout.Name())
+	errData, _ := os.ReadFile(stderr.Name())
+	out, errs := string(outData), string(errData)
+	if !strings.Contains(errs, "split error") || strings.Contains(errs, "split warning") || strings.Contains(errs, "split info") {
+		t.Errorf("stderr should only hold the error record: %q", errs)
+	}
+	if strings.Contains(out, "split error") || !strings.Contains(out, "split warning") || !strings.Contains(out, "split info") {
+		t.Errorf("stdout should hold all records below error: %q", out)
+	}
+}
+
+func TestSetupRefusesOverwrite(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cpu.pprof")
+	if err := os.WriteFile(file, []byte("capture"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig
+	cfg.CPUProfile = file
+	if err := SetupWith(cfg); err == nil {
+		Handler.StopCPUProfile()
+		t.Fatal("expected error for existing CPU profile file")
+	}
+	if data, _ := os.ReadFile(file); string(data) != "capture" {
+		t.Fatalf("existing file modified: %q", data)
+	}
+}
+
+func TestSetupErrorContext(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "missing", "cpu.pprof")
+	cfg := DefaultConfig
+	cfg.CPUProfile = file
+	err := SetupWith(cfg)
+	if err == nil {
+		Handler.StopCPUProfile()
+		t.Fatal("expected error for unwritable CPU profile path")
+	}
+	want := fmt.Sprintf("--%s %q: ", cpuprofileFlag.Name, file)
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("error %q does not start with %q", err, want)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("error %q does not wrap fs.ErrNotExist", err)
+	}
+}
+
+func TestStartPProfContext(t *testing.T) {
+	// Reserve a free port for the server to listen on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := StartPProfContext(ctx, addr, false); err != nil {
+		t.Fatalf("failed to start pprof server: %v", err)
+	}
+	resp, err := http.Get("http://" + addr + "/debug/healthz")
+	if err != nil {
+		t.Fatalf("failed to query pprof server: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status mismatch: have %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	cancel()
+
+	// The port should be released shortly after the context is canceled.
+	for deadline := time.Now().Add(pprofShutdownTimeout); ; {
+		l, err := net.Listen("tcp", addr)
+		if err == nil {
+			l.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("port not released after cancel: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStartPProfReplaces(t *testing.T) {
+	// Reserve a free port for the server to listen on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	// Restarting on the same address only works if the first server's listener
+	// was released.
+	if err := StartPProf(addr, false); err != nil {
+		t.Fatalf("failed to start pprof server: %v", err)
+	}
+	first := pprofServer
+	if err := StartPProf(addr, false); err != nil {
+		t.Fatalf("failed to restart pprof server: %v", err)
+	}
+	if pprofServer == first {
+		t.Fatal("pprof server not replaced")
+	}
+	stopPProf()
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("port not released after stop: %v", err)
+	}
+	l.Close()
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+		err   bool
+	}{
+		{input: "0", want: 0},
+		{input: "1024", want: 1024},
+		{input: "100B", want: 100},
+		{input: "512MB", want: 512_000_000},
+		{input: "8GiB", want: 8 << 30},
+		{input: "8 gib", want: 8 << 30},
+		{input: "1.5KiB", want: 1536},
+		{input: "2TB", want: 2_000_000_000_000},
+		{input: "", err: true},
+		{input: "GiB", err: true},
+		{input: "8XB", err: true},
+		{input: "1.2.3MB", err: true},
+		{input: "100000000TiB", err: true},
+	}
+	for _, tt := range tests {
+		have, err := parseByteSize(tt.input)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: expected error, got %d", tt.input, have)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.input, err)
+		} else if have != tt.want {
+			t.Errorf("%q: size mismatch: have %d, want %d", tt.input, have, tt.want)
+		}
+	}
+}
+
+func TestMergeVmodule(t *testing.T) {
+	tests := []struct {
+		rulesets []string
+		want     string
+	}{
+		{[]string{"", ""}, ""},
+		{[]string{"eth/*=3", ""}, "eth/*=3"},
+		{[]string{"eth/*=3,p2p=4", "eth/*=5"}, "eth/*=5,p2p=4"},
+		{[]string{"eth/*=3", "eth/downloader=5"}, "eth/downloader=5,eth/*=3"},
+		{[]string{"p2p=2", " p2p = 4 ,", "p2p=5"}, "p2p=5"},
+	}
+	for _, tt := range tests {
+		if have := mergeVmodule(tt.rulesets...); have != tt.want {
+			t.Errorf("%q: merge mismatch: have %q, want %q", tt.rulesets, have, tt.want)
+		}
+	}
+}
+
+func TestSetupEnvVars(t *testing.T) {
+	defer func(rate int) { runtime.MemProfileRate = rate }(runtime.MemProfileRate)
+
+	t.Setenv("GETH_PPROF_MEMPROFILERATE", "0")
+	runSetup(t)
+	if runtime.MemProfileRate != 0 {
+		t.Fatalf("memory profile rate mismatch: have %d, want 0", runtime.MemProfileRate)
+	}
+	// Explicit command line flags take precedence over the environment.
+	runSetup(t, "--pprof.memprofilerate=1024")
+	if runtime.MemProfileRate != 1024 {
+		t.Fatalf("memory profile rate mismatch: have %d, want 1024", runtime.MemProfileRate)
+	}
+}
+
+func TestTraceRotation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "trace.out")
+	cfg := DefaultConfig
+	cfg.Trace = file
+	cfg.TraceRotateDuration = 50 * time.Millisecond
+	if err := SetupWith(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer Handler.StopGoTrace()
+	defer stopTraceRotation()
+
+	second := filepath.Join(filepath.Dir(file), "trace.0002.out")
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if _, err := os.Stat(second); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("segment %s not created", second)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(file), "trace.0001.out")); err != nil {
+		t.Fatalf("first segment missing: %v", err)
+	}
+	if _, err := os.Stat(file); err == nil {
+		t.Fatalf("unsegmented trace file %s written", file)
+	}
+}
+
+func TestPruneDumps(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"heap-2022-01-01T00-00-00.pprof",
+		"heap-2022-01-02T00-00-00.pprof",
+		"heap-2022-01-03T00-00-00.pprof",
+		"goroutines-2022-01-01T00-00-00.txt",
+	}
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "snapshot-2022-01-01T00-00-00-1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(keep int) { dumpsKeep = keep }(dumpsKeep)
+	dumpsKeep = 1
+
+	pruneDumps(dir, "heap-")
+	pruneDumps(dir, "snapshot-")
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var have []string
+	for _, entry := range entries {
+		have = append(have, entry.Name())
+	}
+	want := []string{
+		"goroutines-2022-01-01T00-00-00.txt",
+		"heap-2022-01-03T00-00-00.pprof",
+		"snapshot-2022-01-01T00-00-00-1",
+	}
+	if strings.Join(have, ",") != strings.Join(want, ",") {
+		t.Fatalf("dumps mismatch: have %v, want %v", have, want)
+	}
+}
+
+func TestLogConfigHandler(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.LogFormat = "json"
+	cfg.Vmodule = "p2p=5"
+	cfg.Debug = true
+	if err := SetupWith(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer SetupWith(DefaultConfig)
+
+	rec := httptest.NewRecorder()
+	logConfigHandler(rec, httptest.NewRequest(http.MethodGet, "/debug/logconfig", nil))
+
+	var have struct {
+		Verbosity int    `json:"verbosity"`
+		Vmodule   string `json:"vmodule"`
+		Format    string `json:"format"`
+		Origins   bool   `json:"origins"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&have); err != nil {
+		t.Fatal(err)
+	}
+	if have.Verbosity != cfg.Verbosity || have.Vmodule != "p2p=5" || have.Format != "json" || !have.Origins {
+		t.Fatalf("log config mismatch: %+v", have)
+	}
+}
+
+func TestConsoleHandlerColor(t *testing.T) {
+	tests := []struct {
+		color string
+		want  bool
+	}{
+		{"always", true},
+		{"never", false},
+		{"auto", false}, // a regular file is not a terminal
+	}
+	for _, tt := range tests {
+		f, err := os.CreateTemp(t.TempDir(), "log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := newConsoleHandler(f, "terminal", tt.color)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger := log.New()
+		logger.SetHandler(h)
+		logger.Info("colored?")
+		f.Close()
+
+		data, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := strings.Contains(string(data), "\x1b["); have != tt.want {
+			t.Errorf("color %q: colored output %v, want %v: %q", tt.color, have, tt.want, data)
+		}
+	}
+}
+
+func TestSetupRequiresTLSPair(t *testing.T) {
+	// The pair is validated whether or not the pprof server is enabled.
+	for _, pprof := range []bool{false, true} {
+		cfg := DefaultConfig
+		cfg.Pprof = pprof
+		cfg.PprofTLSCert = "cert.pem"
+		if err := SetupWith(cfg); err == nil {
+			stopPProf()
+			t.Errorf("pprof %v: expected error for certificate without key", pprof)
+		}
+	}
+}
+
+func TestSetupRequiresAuthUser(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Pprof = true
+	cfg.PprofAuthPassword = "secret"
+	if err := SetupWith(cfg); err == nil {
+		stopPProf()
+		t.Fatal("expected error for password without user")
+	}
+}
+
+func TestSetupLogFileVerbosity(t *testing.T) {
+	defer SetupWith(DefaultConfig)
+
+	file := filepath.Join(t.TempDir(), "geth.log")
+	cfg := DefaultConfig
+	cfg.LogFile = file
+	if err := SetupWith(cfg); err != nil {
+		t.Fatal(err)
+	}
+	log.Info("unset file verbosity")
+
+	debug := int(log.LvlDebug)
+	cfg.LogFileVerbosity = &debug
+	if err := SetupWith(cfg); err != nil {
+		t.Fatal(err)
+	}
+	log.Debug("explicit file verbosity")
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"unset file verbosity", "explicit file verbosity"} {
+		if !strings.Contains(string(data), msg) {
+			t.Errorf("log file misses %q: %q", msg, data)
+		}
+	}
+}
+
+func TestExitCancelsDelayedPProf(t *testing.T) {
+	// Reserve a free port for the server to listen on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cfg := DefaultConfig
+	cfg.Pprof = true
+	cfg.PprofAddr = "127.0.0.1"
+	cfg.PprofPort = port
+	cfg.PprofStartDelay = 100 * time.Millisecond
+	if err := SetupWith(cfg); err != nil {
+		t.Fatal(err)
+	}
+	stopPProf()
+	time.Sleep(3 * cfg.PprofStartDelay)
+
+	l, err = net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("pprof server started after exit: %v", err)
+	}
+	l.Close()
+}
+
+func TestProfileHandlers(t *testing.T) {
+	mux := http.NewServeMux()
+	registerProfileHandlers(mux)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	if rec := get("/debug/pprof/"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine?debug=1") {
+		t.Errorf("index mismatch: %d %q", rec.Code, rec.Body)
+	}
+	if rec := get("/debug/pprof/goroutine?debug=1"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "TestProfileHandlers") {
+		t.Errorf("goroutine profile mismatch: %d %q", rec.Code, rec.Body)
+	}
+	if rec := get("/debug/pprof/heap"); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("heap profile mismatch: %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if rec := get("/debug/pprof/nonexistent"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown profile status mismatch: have %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := get("/debug/pprof/symbol"); rec.Code != http.StatusOK || rec.Body.String() != "num_symbols: 1\n" {
+		t.Errorf("symbol mismatch: %d %q", rec.Code, rec.Body)
+	}
+	if rec := get("/debug/pprof/trace?seconds=0.1"); rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "go ") {
+		t.Errorf("trace mismatch: %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if rec := get("/debug/pprof/allocs?seconds=1"); rec.Code != http.StatusOK || rec.Header().Get("Content-Disposition") != `attachment; filename="allocs-delta"` {
+		t.Errorf("delta profile mismatch: %d %q", rec.Code, rec.Body)
+	}
+	for _, path := range []string{"/debug/pprof/allocs?seconds=0.5", "/debug/pprof/allocs?seconds=1&debug=1", "/debug/pprof/cpu?seconds=1"} {
+		if rec := get(path); rec.Code != http.StatusBadRequest && rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status mismatch: have %d, want error", path, rec.Code)
+		}
+	}
+	// The profiling endpoints must not leak onto the default mux.
+	if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)); pattern != "" {
+		t.Errorf("http.DefaultServeMux serves /debug/pprof/ with pattern %q", pattern)
+	}
+}
+
+// deltaSink keeps the allocations of TestDeltaProfile reachable.
+var deltaSink [][]byte
+
+func TestDeltaProfile(t *testing.T) {
+	// allocSpace sums up the alloc_space values of a decoded heap profile.
+	allocSpace := func(fields []protoField) (total int64) {
+		for _, field := range fields {
+			if field.num != profileSample {
+				continue
+			}
+			sample, err := decodeProto(field.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var values []uint64
+			for _, f := range sample {
+				if f.num == 2 {
+					if values, err = protoValues(f, values); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			total += int64(values[1])
+		}
+		return total
+	}
+	delta := func(p0, p1 []protoField) []protoField {
+		var buf bytes.Buffer
+		if err := writeDeltaProfile(&buf, p0, p1); err != nil {
+			t.Fatal(err)
+		}
+		fields, err := readProfile(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fields
+	}
+	runtime.GC()
+	p0, err := collectProfile(pprof.Lookup("allocs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := allocSpace(delta(p0, p0)); have != 0 {
+		t.Errorf("delta of identical profiles allocates %d bytes", have)
+	}
+	for i := 0; i < 32; i++ {
+		deltaSink = append(deltaSink, make([]byte, 1<<20))
+	}
+	deltaSink = nil
+	runtime.GC()
+
+	p1, err := collectProfile(pprof.Lookup("allocs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Allocations this large are sampled with near certainty.
+	have, total := allocSpace(delta(p0, p1)), allocSpace(p1)
+	if have < 16<<20 || have > total {
+		t.Errorf("delta alloc_space mismatch: have %d, want 32MiB of total %d", have, total)
+	}
+}
+
+func TestCPUProfileRate(t *testing.T) {
+	// period returns the sampling period in nanoseconds of a CPU profile.
+	period := func(rate int) uint64 {
+		var buf bytes.Buffer
+		if err := startCPUProfile(&buf, rate); err != nil {
+			t.Fatal(err)
+		}
+		pprof.StopCPUProfile()
+
+		fields, err := readProfile(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range fields {
+			if field.num == profilePeriod {
+				return field.v
+			}
+		}
+		t.Fatal("profile has no period")
+		return 0
+	}
+	if have, want := period(500), uint64(time.Second/500); have != want {
+		t.Errorf("custom rate period mismatch: have %d, want %d", have, want)
+	}
+	// The rate must be reset once the custom rate profile stopped.
+	if have, want := period(0), uint64(time.Second/defaultCPUProfileRate); have != want {
+		t.Errorf("default rate period mismatch: have %d, want %d", have, want)
+	}
+}
+
+func TestTraceHandlerWriteTimeout(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(traceHandler))
+	srv.Config.WriteTimeout = 2 * time.Second
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?seconds=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status mismatch: have %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
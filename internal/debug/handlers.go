@@ -0,0 +1,339 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debug
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"golang.org/x/time/rate"
+)
+
+// basicAuthHandler wraps next, only delegating requests that carry the given
+// HTTP Basic credentials. All other requests are rejected with 401.
+func basicAuthHandler(user, password string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(u), []byte(user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="pprof"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitHandler wraps next, allowing on average limit requests per second
+// with bursts of up to one second worth of requests. Requests over the limit
+// are rejected with 429.
+func rateLimitHandler(limit float64, next http.Handler) http.Handler {
+	burst := int(math.Ceil(limit))
+	if burst < 1 {
+		burst = 1
+	}
+	limiter := rate.NewLimiter(rate.Limit(limit), burst)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.Allow() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cmdlineHandler responds with the running program's command line, with
+// arguments separated by NUL bytes like net/http/pprof.Cmdline, but with the
+// values of all flags in pprofRedacted replaced.
+func cmdlineHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, strings.Join(redactArgs(os.Args, pprofRedacted), "\x00"))
+}
+
+// redactArgs returns a copy of args with the values of the given flags,
+// whether passed as --flag=value or --flag value, replaced by "***".
+func redactArgs(args []string, redacted map[string]bool) []string {
+	out := make([]string, len(args))
+	copy(out, args)
+
+	for i := 1; i < len(out); i++ {
+		if !strings.HasPrefix(out[i], "-") {
+			continue
+		}
+		name := strings.TrimLeft(out[i], "-")
+		if pos := strings.IndexByte(name, '='); pos >= 0 {
+			if redacted[name[:pos]] {
+				out[i] = out[i][:len(out[i])-len(name)+pos+1] + "***"
+			}
+			continue
+		}
+		if redacted[name] && i+1 < len(out) {
+			out[i+1] = "***"
+			i++
+		}
+	}
+	return out
+}
+
+// healthzHandler reports that the process is up. It deliberately does no work
+// beyond writing a constant response, so it stays responsive under load.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}` + "\n"))
+}
+
+// verbosityHandler reports the current log verbosity on GET and changes it on
+// POST, reading the new level (0-5) from the "level" form value.
+func verbosityHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		level, err := strconv.Atoi(r.FormValue("level"))
+		if err != nil || level < int(log.LvlCrit) || level > int(log.LvlTrace) {
+			http.Error(w, fmt.Sprintf("invalid level %q, want 0-5", r.FormValue("level")), http.StatusBadRequest)
+			return
+		}
+		glogger.Verbosity(log.Lvl(level))
+		log.Info("Log verbosity changed", "level", level)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"verbosity": int(glogger.GetVerbosity())})
+}
+
+// vmoduleHandler replaces the active vmodule pattern with the "pattern" form
+// value, responding with the previously active pattern.
+func vmoduleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pattern := r.FormValue("pattern")
+	previous := glogger.GetVmodule()
+	if err := glogger.Vmodule(pattern); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Info("Log vmodule changed", "pattern", pattern, "previous", previous)
+	writeJSON(w, map[string]interface{}{"previous": previous})
+}
+
+// logFormatName is the log format chosen at Setup.
+var logFormatName = "terminal"
+
+// logConfigHandler reports the effective logging configuration: the current
+// verbosity and vmodule pattern, including runtime changes, along with the
+// format and origin printing chosen at Setup.
+func logConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"verbosity": int(glogger.GetVerbosity()),
+		"vmodule":   glogger.GetVmodule(),
+		"format":    logFormatName,
+		"origins":   log.PrintingOrigins(),
+	})
+}
+
+// traceLock ensures only one on-demand execution trace runs at a time.
+var traceLock sync.Mutex
+
+// traceHandler captures a Go execution trace for the number of seconds given
+// in the "seconds" query parameter (default 5) and streams it back. Requests
+// arriving while a trace is already running are rejected with 409, as are ones
+// which would outlast the write timeout of the server with 400.
+func traceHandler(w http.ResponseWriter, r *http.Request) {
+	seconds := 5
+	if s := r.FormValue("seconds"); s != "" {
+		var err error
+		if seconds, err = strconv.Atoi(s); err != nil || seconds <= 0 {
+			http.Error(w, fmt.Sprintf("invalid seconds %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	if durationExceedsWriteTimeout(r, float64(seconds)) {
+		http.Error(w, fmt.Sprintf("trace duration %ds exceeds the server's write timeout", seconds), http.StatusBadRequest)
+		return
+	}
+	if !traceLock.TryLock() {
+		http.Error(w, "trace already in progress", http.StatusConflict)
+		return
+	}
+	defer traceLock.Unlock()
+
+	f, err := os.CreateTemp("", "geth-trace-*.out")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	file := f.Name()
+	f.Close()
+	defer os.Remove(file)
+
+	if err := Handler.StartGoTrace(file); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	select {
+	case <-time.After(time.Duration(seconds) * time.Second):
+	case <-r.Context().Done():
+	}
+	if err := Handler.StopGoTrace(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace"`)
+	http.ServeFile(w, r, file)
+}
+
+// gcLock serializes forced garbage collections, so that the memory statistics
+// of concurrent requests don't overlap.
+var gcLock sync.Mutex
+
+// gcStats is the subset of runtime.MemStats reported by gcHandler.
+type gcStats struct {
+	HeapAlloc   uint64 `json:"heapAlloc"`
+	HeapInuse   uint64 `json:"heapInuse"`
+	HeapObjects uint64 `json:"heapObjects"`
+	Sys         uint64 `json:"sys"`
+}
+
+func readGCStats() gcStats {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return gcStats{
+		HeapAlloc:   stats.HeapAlloc,
+		HeapInuse:   stats.HeapInuse,
+		HeapObjects: stats.HeapObjects,
+		Sys:         stats.Sys,
+	}
+}
+
+// gcHandler forces a garbage collection on POST and responds with the memory
+// statistics from before and after it, along with the reclaimed heap bytes.
+func gcHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	gcLock.Lock()
+	defer gcLock.Unlock()
+
+	start := time.Now()
+	before := readGCStats()
+	runtime.GC()
+	after := readGCStats()
+	elapsed := time.Since(start)
+
+	freed := int64(before.HeapAlloc) - int64(after.HeapAlloc)
+	log.Info("Forced garbage collection", "freed", freed, "elapsed", elapsed)
+	writeJSON(w, map[string]interface{}{
+		"before":  before,
+		"after":   after,
+		"freed":   freed,
+		"elapsed": elapsed.String(),
+	})
+}
+
+// profileCustomHandler streams a CPU profile covering the number of seconds
+// given in the "seconds" query parameter (default 30), sampled at the rate in
+// Hz given by the "rate" parameter, or --pprof.cpuprofile.rate if omitted.
+func profileCustomHandler(w http.ResponseWriter, r *http.Request) {
+	seconds := 30
+	if s := r.FormValue("seconds"); s != "" {
+		var err error
+		if seconds, err = strconv.Atoi(s); err != nil || seconds <= 0 {
+			http.Error(w, fmt.Sprintf("invalid seconds %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	rate := cpuProfileRate
+	if s := r.FormValue("rate"); s != "" {
+		var err error
+		if rate, err = strconv.Atoi(s); err != nil || rate <= 0 || rate > maxCPUProfileRate {
+			http.Error(w, fmt.Sprintf("invalid rate %q, want 1-%d", s, maxCPUProfileRate), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := startCPUProfile(w, rate); err != nil {
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Disposition")
+		http.Error(w, fmt.Sprintf("could not enable CPU profiling: %v", err), http.StatusInternalServerError)
+		return
+	}
+	select {
+	case <-time.After(time.Duration(seconds) * time.Second):
+	case <-r.Context().Done():
+	}
+	pprof.StopCPUProfile()
+}
+
+// snapshotDir is the directory profile snapshots are written into, the system
+// temporary directory if empty.
+var snapshotDir string
+
+// snapshotHandler captures all runtime profiles into a new directory on POST
+// and responds with its path.
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	dir := snapshotDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	path, err := writeSnapshot(dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"path": path})
+}
+
+// writeJSON encodes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debug("Failed to write debug response", "err", err)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debug
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// heapWatchInterval is the interval at which the heap size is polled for the
+// threshold triggered heap dumps.
+const heapWatchInterval = 5 * time.Second
+
+// dumpsKeep is the number of dumps of each kind retained in the heap dump
+// directory, 0 to keep all of them.
+var dumpsKeep int
+
+// pruneDumps removes the oldest dumps with the given name prefix from dir, so
+// that at most dumpsKeep of them remain.
+func pruneDumps(dir, prefix string) {
+	if dumpsKeep <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Warn("Failed to list dumps", "dir", dir, "err", err)
+		return
+	}
+	var dumps []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix) {
+			dumps = append(dumps, entry.Name())
+		}
+	}
+	if len(dumps) <= dumpsKeep {
+		return
+	}
+	// Dump names embed their creation time, so they sort chronologically.
+	sort.Strings(dumps)
+	for _, name := range dumps[:len(dumps)-dumpsKeep] {
+		path := filepath.Join(dir, name)
+		if err := os.RemoveAll(path); err != nil {
+			log.Warn("Failed to remove old dump", "dump", path, "err", err)
+			continue
+		}
+		log.Debug("Removed old dump", "dump", path)
+	}
+}
+
+// writeHeapDump writes a heap profile into a timestamped file within dir.
+func writeHeapDump(dir string) {
+	file := filepath.Join(dir, "heap-"+time.Now().Format("2006-01-02T15-04-05")+".pprof")
+	if err := writeProfile("heap", file); err != nil {
+		log.Error("Failed to write heap dump", "dump", file, "err", err)
+		return
+	}
+	pruneDumps(dir, "heap-")
+	uploadProfile(file)
+}
+
+// heapWatchStop is closed to end the running heap watcher, nil if none.
+var heapWatchStop chan struct{}
+
+// startHeapWatch starts watchHeap in the background, replacing the heap watcher
+// of a previous setup.
+func startHeapWatch(dir string, threshold uint64) {
+	stopHeapWatch()
+	heapWatchStop = make(chan struct{})
+	go watchHeap(dir, threshold, heapWatchStop)
+}
+
+// stopHeapWatch ends the running heap watcher, if any.
+func stopHeapWatch() {
+	if heapWatchStop != nil {
+		close(heapWatchStop)
+		heapWatchStop = nil
+	}
+}
+
+// watchHeap polls the allocated heap size until stop is closed, writing a heap
+// dump into dir when it crosses threshold bytes. Another dump is only written
+// after the heap shrank back below the threshold and crossed it again.
+func watchHeap(dir string, threshold uint64, stop chan struct{}) {
+	var (
+		stats  = new(runtime.MemStats)
+		armed  = true
+		ticker = time.NewTicker(heapWatchInterval)
+	)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		runtime.ReadMemStats(stats)
+		switch {
+		case armed && stats.HeapAlloc >= threshold:
+			log.Warn("Heap size crossed dump threshold", "heap", stats.HeapAlloc, "threshold", threshold)
+			writeHeapDump(dir)
+			armed = false
+		case !armed && stats.HeapAlloc < threshold:
+			armed = true
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build linux
+// +build linux
+
+package debug
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// journaldSocket is the datagram socket of the systemd journal.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldPriorities maps log levels to syslog priorities used by journald.
+var journaldPriorities = map[log.Lvl]int{
+	log.LvlCrit:  2,
+	log.LvlError: 3,
+	log.LvlWarn:  4,
+	log.LvlInfo:  6,
+	log.LvlDebug: 7,
+	log.LvlTrace: 7,
+}
+
+// newJournaldHandler creates a log handler sending records to the systemd
+// journal using its native protocol, with the context of each record stored
+// as journal fields.
+func newJournaldHandler() (log.Handler, error) {
+	if _, err := os.Stat(journaldSocket); err != nil {
+		return nil, fmt.Errorf("journald not available: %v", err)
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	tag := filepath.Base(os.Args[0])
+
+	h := log.FuncHandler(func(r *log.Record) error {
+		var buf bytes.Buffer
+		writeJournaldField(&buf, "MESSAGE", r.Msg)
+		writeJournaldField(&buf, "PRIORITY", fmt.Sprint(journaldPriorities[r.Lvl]))
+		writeJournaldField(&buf, "SYSLOG_IDENTIFIER", tag)
+		writeJournaldField(&buf, "CODE_FILE", fmt.Sprintf("%+s", r.Call))
+		writeJournaldField(&buf, "CODE_LINE", fmt.Sprintf("%d", r.Call))
+		writeJournaldField(&buf, "CODE_FUNC", fmt.Sprintf("%n", r.Call))
+		for i := 0; i < len(r.Ctx); i += 2 {
+			writeJournaldField(&buf, journaldFieldName(fmt.Sprint(r.Ctx[i])), formatJournaldValue(r.Ctx[i+1]))
+		}
+		_, err := conn.Write(buf.Bytes())
+		return err
+	})
+	return log.LazyHandler(log.SyncHandler(h)), nil
+}
+
+// writeJournaldField appends a field to a journal datagram, using the binary
+// encoding for values that span multiple lines.
+func writeJournaldField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if !strings.ContainsRune(value, '\n') {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journaldFieldName converts a log context key into a valid journal field
+// name, which consists of upper case letters, digits and underscores and
+// doesn't start with an underscore or digit.
+func journaldFieldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || name[0] == '_' || (name[0] >= '0' && name[0] <= '9') {
+		return "F_" + string(name)
+	}
+	return string(name)
+}
+
+// formatJournaldValue renders a log context value as a journal field value.
+func formatJournaldValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case string:
+		return v
+	default:
+		return fmt.Sprintf("%+v", v)
+	}
+}
@@ -0,0 +1,530 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debug
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime/pprof"
+)
+
+// Delta profiles are computed by merging two snapshots of a profile, the older
+// one with negated sample values. net/http/pprof does so with an internal copy
+// of github.com/google/pprof/profile, which can't be imported. The snapshots
+// are merged on their protocol buffer encoding instead, interning strings,
+// mappings, functions and locations by content, since the IDs assigned by
+// runtime/pprof differ between snapshots.
+
+var errBadProfile = errors.New("malformed profile")
+
+// Field numbers of the messages in the pprof profile.proto format.
+const (
+	profileSampleType        = 1
+	profileSample            = 2
+	profileMapping           = 3
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileDropFrames        = 7
+	profileKeepFrames        = 8
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileComment           = 13
+	profileDefaultSampleType = 14
+)
+
+// profileSupportsDelta lists the profiles a delta can be computed for, which
+// are those holding counts rather than samples of the current state.
+var profileSupportsDelta = map[string]bool{
+	"allocs":       true,
+	"block":        true,
+	"goroutine":    true,
+	"heap":         true,
+	"mutex":        true,
+	"threadcreate": true,
+}
+
+// protoField is a single field of an encoded protocol buffer message. Varint
+// fields carry their value in v, length delimited ones their payload in b.
+type protoField struct {
+	num int
+	v   uint64
+	b   []byte
+}
+
+// decodeProto splits an encoded message into its fields. Only the varint and
+// length delimited wire types are supported, as runtime/pprof uses no others.
+func decodeProto(data []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errBadProfile
+		}
+		data = data[n:]
+
+		field := protoField{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			if field.v, n = binary.Uvarint(data); n <= 0 {
+				return nil, errBadProfile
+			}
+			data = data[n:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return nil, errBadProfile
+			}
+			field.b, data = data[n:n+int(size)], data[n+int(size):]
+		default:
+			return nil, errBadProfile
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// protoValues returns the values of a repeated integer field, which may be
+// encoded one per field or packed into a single one.
+func protoValues(field protoField, values []uint64) ([]uint64, error) {
+	if field.b == nil {
+		return append(values, field.v), nil
+	}
+	for data := field.b; len(data) > 0; {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errBadProfile
+		}
+		values, data = append(values, v), data[n:]
+	}
+	return values, nil
+}
+
+// protoBuffer accumulates an encoded protocol buffer message.
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(num int, v uint64) {
+	*b = binary.AppendUvarint(*b, uint64(num)<<3)
+	*b = binary.AppendUvarint(*b, v)
+}
+
+func (b *protoBuffer) bytes(num int, data []byte) {
+	*b = binary.AppendUvarint(*b, uint64(num)<<3|2)
+	*b = binary.AppendUvarint(*b, uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *protoBuffer) packed(num int, values []uint64) {
+	var data protoBuffer
+	for _, v := range values {
+		data = binary.AppendUvarint(data, v)
+	}
+	b.bytes(num, data)
+}
+
+// profileTable interns the messages of one kind, e.g. the locations, assigning
+// IDs in order of appearance. Messages are keyed by their encoding without the
+// ID, which is field 1 in all of them.
+type profileTable struct {
+	ids  map[string]uint64
+	msgs [][]byte
+}
+
+func (t *profileTable) intern(msg protoBuffer) uint64 {
+	if id, ok := t.ids[string(msg)]; ok {
+		return id
+	}
+	if t.ids == nil {
+		t.ids = make(map[string]uint64)
+	}
+	id := uint64(len(t.msgs) + 1)
+	t.ids[string(msg)] = id
+
+	var full protoBuffer
+	full.varint(1, id)
+	t.msgs = append(t.msgs, append(full, msg...))
+	return id
+}
+
+// mergedSample is a sample of the merged profile.
+type mergedSample struct {
+	key    []byte // Encoded location IDs and labels, identifying the sample
+	values []int64
+}
+
+// profileMerger merges decoded profiles into a single one.
+type profileMerger struct {
+	strings   []string
+	stringIDs map[string]uint64
+
+	mappings  profileTable
+	functions profileTable
+	locations profileTable
+
+	samples   []*mergedSample
+	sampleIDs map[string]*mergedSample
+}
+
+// profileSource translates the string indices and IDs of a profile being merged
+// to those of the merged profile.
+type profileSource struct {
+	strings   []string
+	mappings  map[uint64]uint64
+	functions map[uint64]uint64
+	locations map[uint64]uint64
+}
+
+func (m *profileMerger) str(src *profileSource, index uint64) (uint64, error) {
+	if index >= uint64(len(src.strings)) {
+		return 0, errBadProfile
+	}
+	s := src.strings[index]
+	if id, ok := m.stringIDs[s]; ok {
+		return id, nil
+	}
+	if m.stringIDs == nil {
+		m.stringIDs = make(map[string]uint64)
+	}
+	id := uint64(len(m.strings))
+	m.strings = append(m.strings, s)
+	m.stringIDs[s] = id
+	return id, nil
+}
+
+// remap re-encodes msg, translating the string indices in the fields listed in
+// strs and the IDs in those listed in refs. If hasID is set, field 1 holds the
+// ID of the message, which is returned and left out of the encoding.
+func (m *profileMerger) remap(src *profileSource, msg []byte, hasID bool, strs []int, refs map[int]map[uint64]uint64) (id uint64, out protoBuffer, err error) {
+	fields, err := decodeProto(msg)
+	if err != nil {
+		return 0, nil, err
+	}
+fields:
+	for _, field := range fields {
+		if hasID && field.num == 1 {
+			id = field.v
+			continue
+		}
+		for _, num := range strs {
+			if field.num == num {
+				if field.v, err = m.str(src, field.v); err != nil {
+					return 0, nil, err
+				}
+				out.varint(field.num, field.v)
+				continue fields
+			}
+		}
+		if ids, ok := refs[field.num]; ok {
+			if field.v, ok = ids[field.v]; !ok {
+				return 0, nil, errBadProfile
+			}
+		}
+		if field.b != nil {
+			out.bytes(field.num, field.b)
+		} else {
+			out.varint(field.num, field.v)
+		}
+	}
+	return id, out, nil
+}
+
+// add merges the given decoded profile, multiplying its sample values by scale.
+func (m *profileMerger) add(fields []protoField, scale int64) error {
+	src := &profileSource{
+		mappings:  make(map[uint64]uint64),
+		functions: make(map[uint64]uint64),
+		locations: make(map[uint64]uint64),
+	}
+	for _, field := range fields {
+		if field.num == profileStringTable {
+			src.strings = append(src.strings, string(field.b))
+		}
+	}
+	// Locations refer to mappings and functions, samples to locations, so the
+	// tables are translated in that order.
+	for _, field := range fields {
+		var err error
+		switch field.num {
+		case profileMapping:
+			err = m.addMapping(src, field.b)
+		case profileFunction:
+			err = m.addFunction(src, field.b)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, field := range fields {
+		if field.num == profileLocation {
+			if err := m.addLocation(src, field.b); err != nil {
+				return err
+			}
+		}
+	}
+	for _, field := range fields {
+		if field.num == profileSample {
+			if err := m.addSample(src, field.b, scale); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *profileMerger) addMapping(src *profileSource, msg []byte) error {
+	// Mapping: 5 filename, 6 build_id.
+	id, out, err := m.remap(src, msg, true, []int{5, 6}, nil)
+	if err != nil {
+		return err
+	}
+	src.mappings[id] = m.mappings.intern(out)
+	return nil
+}
+
+func (m *profileMerger) addFunction(src *profileSource, msg []byte) error {
+	// Function: 2 name, 3 system_name, 4 filename.
+	id, out, err := m.remap(src, msg, true, []int{2, 3, 4}, nil)
+	if err != nil {
+		return err
+	}
+	src.functions[id] = m.functions.intern(out)
+	return nil
+}
+
+func (m *profileMerger) addLocation(src *profileSource, msg []byte) error {
+	fields, err := decodeProto(msg)
+	if err != nil {
+		return err
+	}
+	var (
+		id  uint64
+		out protoBuffer
+	)
+	for _, field := range fields {
+		switch field.num {
+		case 1:
+			id = field.v
+		case 2: // mapping_id
+			mapping, ok := src.mappings[field.v]
+			if !ok && field.v != 0 {
+				return errBadProfile
+			}
+			out.varint(field.num, mapping)
+		case 4: // line, 1 function_id
+			_, line, err := m.remap(src, field.b, false, nil, map[int]map[uint64]uint64{1: src.functions})
+			if err != nil {
+				return err
+			}
+			out.bytes(field.num, line)
+		default:
+			if field.b != nil {
+				out.bytes(field.num, field.b)
+			} else {
+				out.varint(field.num, field.v)
+			}
+		}
+	}
+	src.locations[id] = m.locations.intern(out)
+	return nil
+}
+
+func (m *profileMerger) addSample(src *profileSource, msg []byte, scale int64) error {
+	fields, err := decodeProto(msg)
+	if err != nil {
+		return err
+	}
+	var (
+		locations []uint64
+		values    []uint64
+		labels    protoBuffer
+	)
+	for _, field := range fields {
+		switch field.num {
+		case 1: // location_id
+			if locations, err = protoValues(field, locations); err != nil {
+				return err
+			}
+		case 2: // value
+			if values, err = protoValues(field, values); err != nil {
+				return err
+			}
+		case 3: // label: 1 key, 2 str, 4 num_unit
+			_, label, err := m.remap(src, field.b, false, []int{1, 2, 4}, nil)
+			if err != nil {
+				return err
+			}
+			labels.bytes(field.num, label)
+		}
+	}
+	for i, loc := range locations {
+		var ok bool
+		if locations[i], ok = src.locations[loc]; !ok {
+			return errBadProfile
+		}
+	}
+	var key protoBuffer
+	key.packed(1, locations)
+	key = append(key, labels...)
+
+	sample := m.sampleIDs[string(key)]
+	if sample == nil {
+		if m.sampleIDs == nil {
+			m.sampleIDs = make(map[string]*mergedSample)
+		}
+		sample = &mergedSample{key: key, values: make([]int64, len(values))}
+		m.sampleIDs[string(key)] = sample
+		m.samples = append(m.samples, sample)
+	}
+	if len(sample.values) != len(values) {
+		return errBadProfile
+	}
+	for i, v := range values {
+		sample.values[i] += scale * int64(v)
+	}
+	return nil
+}
+
+// encode writes the merged profile, taking the sample types, period and other
+// metadata from the given decoded profile. Samples which cancelled out are
+// omitted.
+func (m *profileMerger) encode(meta []protoField, timeNanos, durationNanos int64) ([]byte, error) {
+	src := &profileSource{}
+	for _, field := range meta {
+		if field.num == profileStringTable {
+			src.strings = append(src.strings, string(field.b))
+		}
+	}
+	var out protoBuffer
+	for _, field := range meta {
+		var err error
+		switch field.num {
+		case profileSampleType, profilePeriodType:
+			// ValueType: 1 type, 2 unit.
+			var msg protoBuffer
+			if _, msg, err = m.remap(src, field.b, false, []int{1, 2}, nil); err == nil {
+				out.bytes(field.num, msg)
+			}
+		case profileDropFrames, profileKeepFrames, profileComment, profileDefaultSampleType:
+			// Comments are repeated, the others are written one per field too.
+			var indices []uint64
+			if indices, err = protoValues(field, nil); err != nil {
+				break
+			}
+			for _, index := range indices {
+				if index, err = m.str(src, index); err != nil {
+					break
+				}
+				out.varint(field.num, index)
+			}
+		case profilePeriod:
+			out.varint(field.num, field.v)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+sampleLoop:
+	for _, sample := range m.samples {
+		for _, v := range sample.values {
+			if v != 0 {
+				values := make([]uint64, len(sample.values))
+				for i, v := range sample.values {
+					values[i] = uint64(v)
+				}
+				msg := append(protoBuffer{}, sample.key...)
+				msg.packed(2, values)
+				out.bytes(profileSample, msg)
+				continue sampleLoop
+			}
+		}
+	}
+	for _, msg := range m.mappings.msgs {
+		out.bytes(profileMapping, msg)
+	}
+	for _, msg := range m.locations.msgs {
+		out.bytes(profileLocation, msg)
+	}
+	for _, msg := range m.functions.msgs {
+		out.bytes(profileFunction, msg)
+	}
+	for _, s := range m.strings {
+		out.bytes(profileStringTable, []byte(s))
+	}
+	out.varint(profileTimeNanos, uint64(timeNanos))
+	out.varint(profileDurationNanos, uint64(durationNanos))
+	return out, nil
+}
+
+// collectProfile writes the given profile and returns its decoded fields.
+func collectProfile(p *pprof.Profile) ([]protoField, error) {
+	var buf bytes.Buffer
+	if err := p.WriteTo(&buf, 0); err != nil {
+		return nil, err
+	}
+	return readProfile(&buf)
+}
+
+// readProfile decodes a gzip compressed profile.
+func readProfile(r io.Reader) ([]protoField, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	return decodeProto(data)
+}
+
+// profileTime returns the time_nanos field of a decoded profile.
+func profileTime(fields []protoField) int64 {
+	for _, field := range fields {
+		if field.num == profileTimeNanos {
+			return int64(field.v)
+		}
+	}
+	return 0
+}
+
+// writeDeltaProfile writes the gzip compressed difference between the decoded
+// profiles p1 and its earlier snapshot p0 to w.
+func writeDeltaProfile(w io.Writer, p0, p1 []protoField) error {
+	// The string table of the merged profile must start with the empty string.
+	m := &profileMerger{strings: []string{""}, stringIDs: map[string]uint64{"": 0}}
+	if err := m.add(p0, -1); err != nil {
+		return err
+	}
+	if err := m.add(p1, 1); err != nil {
+		return err
+	}
+	t0, t1 := profileTime(p0), profileTime(p1)
+	data, err := m.encode(p1, t1, t1-t0)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,261 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debug
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The profiling endpoints are implemented on top of runtime/pprof rather than
+// served by net/http/pprof, whose init function registers them on
+// http.DefaultServeMux as soon as the package is linked in. They follow the
+// semantics of net/http/pprof, so that go tool pprof works against them as usual.
+
+// registerProfileHandlers registers the /debug/pprof endpoints on mux.
+func registerProfileHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprofIndexHandler)
+	mux.HandleFunc("/debug/pprof/cmdline", cmdlineHandler)
+	mux.HandleFunc("/debug/pprof/profile", pprofProfileHandler)
+	mux.HandleFunc("/debug/pprof/symbol", pprofSymbolHandler)
+	mux.HandleFunc("/debug/pprof/trace", pprofTraceHandler)
+}
+
+// pprofError responds with the given error, dropping the headers announcing a
+// profile download.
+func pprofError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("X-Go-Pprof", "1")
+	w.Header().Del("Content-Disposition")
+	http.Error(w, msg, status)
+}
+
+// durationExceedsWriteTimeout reports whether a response taking the given
+// number of seconds would be cut off by the write timeout of the server.
+func durationExceedsWriteTimeout(r *http.Request, seconds float64) bool {
+	srv, ok := r.Context().Value(http.ServerContextKey).(*http.Server)
+	return ok && srv.WriteTimeout != 0 && seconds >= srv.WriteTimeout.Seconds()
+}
+
+// sleepRequest waits for d or until the request is canceled.
+func sleepRequest(r *http.Request, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-r.Context().Done():
+	}
+}
+
+// pprofProfileHandler streams a CPU profile covering the number of seconds
+// given in the "seconds" query parameter, 30 by default.
+func pprofProfileHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	seconds, err := strconv.ParseInt(r.FormValue("seconds"), 10, 64)
+	if seconds <= 0 || err != nil {
+		seconds = 30
+	}
+	if durationExceedsWriteTimeout(r, float64(seconds)) {
+		pprofError(w, http.StatusBadRequest, "profile duration exceeds server's WriteTimeout")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		pprofError(w, http.StatusInternalServerError, fmt.Sprintf("Could not enable CPU profiling: %s", err))
+		return
+	}
+	sleepRequest(r, time.Duration(seconds)*time.Second)
+	pprof.StopCPUProfile()
+}
+
+// pprofTraceHandler streams an execution trace covering the possibly fractional
+// number of seconds given in the "seconds" query parameter, 1 by default.
+func pprofTraceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	seconds, err := strconv.ParseFloat(r.FormValue("seconds"), 64)
+	if seconds <= 0 || err != nil {
+		seconds = 1
+	}
+	if durationExceedsWriteTimeout(r, seconds) {
+		pprofError(w, http.StatusBadRequest, "profile duration exceeds server's WriteTimeout")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace"`)
+	if err := trace.Start(w); err != nil {
+		pprofError(w, http.StatusInternalServerError, fmt.Sprintf("Could not enable tracing: %s", err))
+		return
+	}
+	sleepRequest(r, time.Duration(seconds*float64(time.Second)))
+	trace.Stop()
+}
+
+// pprofIndexHandler serves the runtime profile named by the request path, e.g.
+// /debug/pprof/heap, and the list of available profiles on /debug/pprof/. The
+// "debug" query parameter selects the text format like in net/http/pprof, and a
+// positive "gc" parameter runs a garbage collection before taking a heap profile.
+// Given a "seconds" parameter, the difference of the profile over that duration
+// is served instead.
+func pprofIndexHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	if name == "" {
+		writeProfileIndex(w)
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	profile := pprof.Lookup(name)
+	if profile == nil {
+		pprofError(w, http.StatusNotFound, "Unknown profile")
+		return
+	}
+	if seconds := r.FormValue("seconds"); seconds != "" {
+		serveDeltaProfile(w, r, profile, seconds)
+		return
+	}
+	debug, _ := strconv.Atoi(r.FormValue("debug"))
+	if gc, _ := strconv.Atoi(r.FormValue("gc")); gc > 0 && name == "heap" {
+		runtime.GC()
+	}
+	if debug != 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	}
+	profile.WriteTo(w, debug)
+}
+
+// serveDeltaProfile responds with the change of the given profile over the
+// number of seconds in secStr.
+func serveDeltaProfile(w http.ResponseWriter, r *http.Request, p *pprof.Profile, secStr string) {
+	seconds, err := strconv.ParseInt(secStr, 10, 64)
+	if err != nil || seconds <= 0 {
+		pprofError(w, http.StatusBadRequest, `invalid value for "seconds" - must be a positive integer`)
+		return
+	}
+	if !profileSupportsDelta[p.Name()] {
+		pprofError(w, http.StatusBadRequest, `"seconds" parameter is not supported for this profile type`)
+		return
+	}
+	if durationExceedsWriteTimeout(r, float64(seconds)) {
+		pprofError(w, http.StatusBadRequest, "profile duration exceeds server's WriteTimeout")
+		return
+	}
+	if debug, _ := strconv.Atoi(r.FormValue("debug")); debug != 0 {
+		pprofError(w, http.StatusBadRequest, "seconds and debug params are incompatible")
+		return
+	}
+	p0, err := collectProfile(p)
+	if err != nil {
+		pprofError(w, http.StatusInternalServerError, "failed to collect profile")
+		return
+	}
+	t := time.NewTimer(time.Duration(seconds) * time.Second)
+	defer t.Stop()
+
+	select {
+	case <-r.Context().Done():
+		if err := r.Context().Err(); err == context.DeadlineExceeded {
+			pprofError(w, http.StatusRequestTimeout, err.Error())
+		} else {
+			pprofError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	case <-t.C:
+	}
+	p1, err := collectProfile(p)
+	if err != nil {
+		pprofError(w, http.StatusInternalServerError, "failed to collect profile")
+		return
+	}
+	var delta bytes.Buffer
+	if err := writeDeltaProfile(&delta, p0, p1); err != nil {
+		pprofError(w, http.StatusInternalServerError, "failed to compute delta")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-delta"`, p.Name()))
+	w.Write(delta.Bytes())
+}
+
+// writeProfileIndex writes an HTML page linking all available profiles.
+func writeProfileIndex(w http.ResponseWriter) {
+	profiles := pprof.Profiles()
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name() < profiles[j].Name() })
+
+	var b bytes.Buffer
+	b.WriteString("<html>\n<head><title>/debug/pprof/</title></head>\n<body>\n/debug/pprof/<br>\n<br>\nTypes of profiles available:\n<table>\n<thead><td>Count</td><td>Profile</td></thead>\n")
+	for _, p := range profiles {
+		name := html.EscapeString(p.Name())
+		fmt.Fprintf(&b, "<tr><td>%d</td><td><a href='%s?debug=1'>%s</a></td></tr>\n", p.Count(), name, name)
+	}
+	for _, name := range []string{"cmdline", "profile", "symbol", "trace"} {
+		fmt.Fprintf(&b, "<tr><td></td><td><a href='%s'>%s</a></td></tr>\n", name, name)
+	}
+	b.WriteString("</table>\n<a href='goroutine?debug=2'>full goroutine stack dump</a>\n</body>\n</html>\n")
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+// pprofSymbolHandler looks up the program counters listed in the request, as
+// the query string of a GET or the body of a POST separated by '+', and
+// responds with a table mapping them to function names, like net/http/pprof.
+func pprofSymbolHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	var in *bufio.Reader
+	if r.Method == http.MethodPost {
+		in = bufio.NewReader(r.Body)
+	} else {
+		in = bufio.NewReader(strings.NewReader(r.URL.RawQuery))
+	}
+	// The value of num_symbols only tells the client whether symbols are
+	// available at all, not how many there are.
+	var b bytes.Buffer
+	b.WriteString("num_symbols: 1\n")
+	for {
+		word, err := in.ReadSlice('+')
+		if err == nil {
+			word = word[:len(word)-1]
+		}
+		if pc, _ := strconv.ParseUint(string(word), 0, 64); pc != 0 {
+			if f := runtime.FuncForPC(uintptr(pc)); f != nil {
+				fmt.Fprintf(&b, "%#x %s\n", pc, f.Name())
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(&b, "reading request: %v\n", err)
+			}
+			break
+		}
+	}
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debug
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is an io.WriteCloser appending to a file on disk, which gets
+// rotated once it grows beyond a size limit. Rotated files are renamed to
+// <path>.1, <path>.2, ... with the highest index being the oldest, and files
+// beyond the configured number of backups are deleted.
+type rotatingFile struct {
+	path       string
+	maxSize    int64 // Maximum file size in bytes before rotating
+	maxBackups int   // Maximum number of rotated files to retain
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+// logFile is the rotating log file written by the handlers installed at Setup,
+// nil if none.
+var logFile *rotatingFile
+
+// closeLogFile closes the log file of the last setup, if any.
+func closeLogFile() {
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+}
+
+// newRotatingFile opens (or creates) the file at path for appending, rotating
+// it after maxSize megabytes have been written.
+func newRotatingFile(path string, maxSize int, maxBackups int) (*rotatingFile, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid maximum log file size %d", maxSize)
+	}
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    int64(maxSize) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the current log file, retrieving its size to continue the count.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+// Write implements io.Writer, rotating the file first if the write would push
+// it over the size limit.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate closes the current file, shifts all backups by one index, dropping
+// those beyond the retention limit, and opens a fresh file.
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	os.Remove(r.backup(r.maxBackups))
+	for i := r.maxBackups - 1; i > 0; i-- {
+		os.Rename(r.backup(i), r.backup(i+1))
+	}
+	if r.maxBackups > 0 {
+		if err := os.Rename(r.path, r.backup(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// backup returns the path of the i-th rotated file.
+func (r *rotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
+
+// Close implements io.Closer, closing the current log file.
+func (r *rotatingFile) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debug
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestRotatingFile opens a rotating file rotated after maxSize bytes.
+func newTestRotatingFile(t *testing.T, path string, maxSize int64, maxBackups int) *rotatingFile {
+	t.Helper()
+
+	r, err := newRotatingFile(path, 1, maxBackups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.maxSize = maxSize
+	return r
+}
+
+// checkFile checks the content of the file at path, or that it does not exist
+// if want is empty.
+func checkFile(t *testing.T, path string, want string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case want == "" && !os.IsNotExist(err):
+		t.Errorf("%s: expected no file, have %q (err %v)", filepath.Base(path), data, err)
+	case want != "" && err != nil:
+		t.Errorf("%s: %v", filepath.Base(path), err)
+	case want != "" && string(data) != want:
+		t.Errorf("%s: content mismatch: have %q, want %q", filepath.Base(path), data, want)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geth.log")
+	r := newTestRotatingFile(t, path, 10, 2)
+	defer r.Close()
+
+	for _, chunk := range []string{"aaaaaaaa", "bbbbbbbb", "cccccccc", "dddddddd"} {
+		if _, err := r.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkFile(t, path, "dddddddd")
+	checkFile(t, path+".1", "cccccccc")
+	checkFile(t, path+".2", "bbbbbbbb")
+	checkFile(t, path+".3", "") // the oldest backup is deleted
+}
+
+func TestRotatingFileNoBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geth.log")
+	r := newTestRotatingFile(t, path, 10, 0)
+	defer r.Close()
+
+	for _, chunk := range []string{"aaaa", "bbbb", "cccccccc"} {
+		if _, err := r.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkFile(t, path, "cccccccc")
+	checkFile(t, path+".1", "")
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geth.log")
+	r := newTestRotatingFile(t, path, 10, 1)
+	r.Write([]byte("aaaaaaaa"))
+	r.Close()
+
+	// Reopening continues the size count of the existing file.
+	r = newTestRotatingFile(t, path, 10, 1)
+	defer r.Close()
+	r.Write([]byte("bbbbbbbb"))
+
+	checkFile(t, path, "bbbbbbbb")
+	checkFile(t, path+".1", "aaaaaaaa")
+}
+
+func TestSetupClosesLogFile(t *testing.T) {
+	dir := t.TempDir()
+	defer SetupWith(DefaultConfig)
+
+	setup := func(file, context string) error {
+		cfg := DefaultConfig
+		cfg.LogFile = filepath.Join(dir, file)
+		cfg.LogContext = context
+		return SetupWith(cfg)
+	}
+	if err := setup("first.log", ""); err != nil {
+		t.Fatal(err)
+	}
+	first := logFile
+
+	// A failed setup must neither keep its own file open nor close the one
+	// still in use.
+	if err := setup("failed.log", "invalid"); err == nil {
+		t.Fatal("setup with invalid log context succeeded")
+	}
+	if logFile != first || first.file == nil {
+		t.Fatal("failed setup replaced the log file")
+	}
+	if err := setup("second.log", ""); err != nil {
+		t.Fatal(err)
+	}
+	second := logFile
+	if first.file != nil {
+		t.Error("first log file not closed on re-setup")
+	}
+	Exit()
+	if logFile != nil || second.file != nil {
+		t.Error("log file not closed on exit")
+	}
+}
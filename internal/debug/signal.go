@@ -0,0 +1,96 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package debug
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// dumpSignals are the channels of the installed dump signal handlers.
+var dumpSignals []chan os.Signal
+
+// notifyDump runs handle whenever the process receives sig, until the handler
+// is uninstalled by removeDumpHandlers.
+func notifyDump(sig os.Signal, handle func()) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, sig)
+	dumpSignals = append(dumpSignals, sigc)
+	go func() {
+		for range sigc {
+			handle()
+		}
+	}()
+}
+
+// removeDumpHandlers uninstalls the heap and stack dump signal handlers, so that
+// repeated setups don't stack them.
+func removeDumpHandlers() {
+	for _, sigc := range dumpSignals {
+		signal.Stop(sigc)
+		close(sigc)
+	}
+	dumpSignals = nil
+}
+
+// installHeapDumpHandler writes a heap profile into dir whenever the process
+// receives SIGUSR1. If snapshot is set, a full profile snapshot is written
+// instead.
+func installHeapDumpHandler(dir string, snapshot bool) {
+	notifyDump(syscall.SIGUSR1, func() {
+		if !snapshot {
+			writeHeapDump(dir)
+			return
+		}
+		if _, err := writeSnapshot(dir); err != nil {
+			log.Error("Failed to write profile snapshot", "dir", dir, "err", err)
+		}
+	})
+	if snapshot {
+		log.Info("Profile snapshots enabled on SIGUSR1", "dir", dir)
+	} else {
+		log.Info("Heap dumps enabled on SIGUSR1", "dir", dir)
+	}
+}
+
+// installStackDumpHandler writes the stacks of all goroutines to stderr whenever
+// the process receives SIGUSR2. If dir is not empty, the stacks are also saved
+// into a file within it.
+func installStackDumpHandler(dir string) {
+	notifyDump(syscall.SIGUSR2, func() {
+		stacks := allStacks()
+		os.Stderr.Write(stacks)
+		if dir == "" {
+			return
+		}
+		file := filepath.Join(dir, "goroutines-"+time.Now().Format("2006-01-02T15-04-05")+".txt")
+		if err := os.WriteFile(file, stacks, 0644); err != nil {
+			log.Error("Failed to write goroutine dump", "dump", file, "err", err)
+			return
+		}
+		log.Info("Wrote goroutine dump", "dump", file)
+		pruneDumps(dir, "goroutines-")
+	})
+}
@@ -0,0 +1,69 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debug
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// snapshotProfiles are the runtime profiles captured by writeSnapshot.
+var snapshotProfiles = []string{"heap", "goroutine", "block", "mutex"}
+
+// snapshotLock serializes profile snapshots, so their captures don't interleave.
+var snapshotLock sync.Mutex
+
+// writeSnapshot captures the heap, goroutine, block and mutex profiles along
+// with a text dump of all goroutine stacks into a new timestamped directory
+// within dir, returning its path.
+func writeSnapshot(dir string) (string, error) {
+	snapshotLock.Lock()
+	defer snapshotLock.Unlock()
+
+	path, err := os.MkdirTemp(dir, "snapshot-"+time.Now().Format("2006-01-02T15-04-05")+"-")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(path, "goroutines.txt"), allStacks(), 0644); err != nil {
+		return "", err
+	}
+	for _, name := range snapshotProfiles {
+		if err := writeProfile(name, filepath.Join(path, name+".pprof")); err != nil {
+			return "", err
+		}
+	}
+	log.Info("Wrote profile snapshot", "dir", path)
+	pruneDumps(dir, "snapshot-")
+	return path, nil
+}
+
+// allStacks returns the formatted stack traces of all goroutines.
+func allStacks() []byte {
+	buf := make([]byte, 1024*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package debug
+
+import (
+	"log/syslog"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// newSyslogHandler creates a log handler writing to the local syslog daemon,
+// or to a remote one if network and addr are set.
+func newSyslogHandler(network, addr string, format log.Format) (log.Handler, error) {
+	tag := filepath.Base(os.Args[0])
+	if addr != "" {
+		return log.SyslogNetHandler(network, addr, syslog.LOG_DAEMON, tag, format)
+	}
+	return log.SyslogHandler(syslog.LOG_DAEMON, tag, format)
+}
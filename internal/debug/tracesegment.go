@@ -0,0 +1,84 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debug
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var (
+	traceRotateStop chan struct{} // Closed to end the running trace rotation, nil if none
+	traceRotateDone chan struct{} // Closed once the trace rotation loop has returned
+)
+
+// traceSegmentFile returns the file name of the n-th segment of a rotated
+// execution trace, numbering the name before its extension: trace.out becomes
+// trace.0001.out.
+func traceSegmentFile(file string, n int) string {
+	ext := filepath.Ext(file)
+	return fmt.Sprintf("%s.%04d%s", strings.TrimSuffix(file, ext), n, ext)
+}
+
+// startTraceRotation cycles the running execution trace into a new segment
+// file every interval. The first segment is expected to be already running.
+// Rotation ends once stopTraceRotation is called or the trace is stopped by
+// other means, such as debug_stopGoTrace.
+func startTraceRotation(file string, interval time.Duration) {
+	stopTraceRotation()
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	traceRotateStop, traceRotateDone = stop, done
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for n := 2; ; n++ {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if err := Handler.StopGoTrace(); err != nil {
+				log.Debug("Execution trace stopped, ending rotation", "err", err)
+				return
+			}
+			if err := Handler.StartGoTrace(traceSegmentFile(file, n)); err != nil {
+				log.Error("Failed to start execution trace segment", "segment", n, "err", err)
+				return
+			}
+		}
+	}()
+}
+
+// stopTraceRotation ends the trace rotation, if any, and waits for a segment
+// switch in progress to complete. The current segment keeps running.
+func stopTraceRotation() {
+	if traceRotateStop == nil {
+		return
+	}
+	close(traceRotateStop)
+	<-traceRotateDone
+	traceRotateStop, traceRotateDone = nil, nil
+}
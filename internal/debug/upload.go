@@ -0,0 +1,177 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debug
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// profileUploadTimeout is the maximum time a single profile upload may take.
+const profileUploadTimeout = 2 * time.Minute
+
+// pprofUploadURL is the s3:// or http(s):// location finished profiles are
+// uploaded to. Profiles are only kept on local disk if it is empty.
+var pprofUploadURL string
+
+// validateUploadURL checks that target is a supported upload location.
+func validateUploadURL(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return fmt.Errorf("missing bucket in %q", target)
+		}
+		return nil
+	case "http", "https":
+		return nil
+	default:
+		return fmt.Errorf("unsupported scheme %q, want s3, http or https", u.Scheme)
+	}
+}
+
+// uploadProfile uploads a finished profile to pprofUploadURL and deletes the
+// local copy. If the upload fails, the error is logged and the file is kept.
+func uploadProfile(file string) {
+	if pprofUploadURL == "" {
+		return
+	}
+	dest, err := putProfile(pprofUploadURL, file)
+	if err != nil {
+		log.Error("Failed to upload profile, keeping local copy", "dump", file, "err", err)
+		return
+	}
+	log.Info("Uploaded profile", "dump", file, "url", dest)
+	if err := os.Remove(file); err != nil {
+		log.Warn("Failed to remove uploaded profile", "dump", file, "err", err)
+	}
+}
+
+// putProfile uploads file below the target location with an HTTP PUT request,
+// returning the URL of the uploaded object. Requests to s3:// targets are
+// signed with the credentials from the standard AWS environment variables.
+func putProfile(target, file string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), profileUploadTimeout)
+	defer cancel()
+
+	var (
+		name = filepath.Base(file)
+		dest string
+		s3   bool
+	)
+	switch u.Scheme {
+	case "s3":
+		// S3-compatible stores are addressed path-style through AWS_ENDPOINT_URL.
+		key := path.Join(strings.TrimPrefix(u.Path, "/"), name)
+		if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+			dest = strings.TrimSuffix(endpoint, "/") + "/" + u.Host + "/" + key
+		} else {
+			dest = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", u.Host, awsRegion(), key)
+		}
+		s3 = true
+	case "http", "https":
+		dest = strings.TrimSuffix(target, "/") + "/" + name
+	default:
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, dest, f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = info.Size()
+
+	if s3 {
+		if err := signS3Request(ctx, req, f); err != nil {
+			return "", err
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return dest, nil
+}
+
+// signS3Request signs req with AWS signature version 4. The payload hash is
+// computed from f, which is rewound afterwards to be sent as the body.
+func signS3Request(ctx context.Context, req *http.Request, f *os.File) error {
+	creds := aws.Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	req.Header.Set("X-Amz-Content-Sha256", hash)
+
+	return v4.NewSigner().SignHTTP(ctx, creds, req, hash, "s3", awsRegion(), time.Now())
+}
+
+// awsRegion returns the AWS region configured in the environment.
+func awsRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	if region := os.Getenv("AWS_DEFAULT_REGION"); region != "" {
+		return region
+	}
+	return "us-east-1"
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package pprofx attaches pprof labels to goroutines, allowing CPU profiles to
+// be filtered by subsystem or processing phase in go tool pprof.
+package pprofx
+
+import (
+	"context"
+	"runtime/pprof"
+	"sync/atomic"
+)
+
+// base holds the context carrying the process-wide labels set by SetLabels.
+var base atomic.Value
+
+// SetLabels sets the process-wide profile labels, given as key/value pairs.
+// They are applied to the calling goroutine and inherited by all goroutines
+// it starts afterwards, so it should be called early, from the main goroutine.
+func SetLabels(labels ...string) {
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels(labels...))
+	base.Store(ctx)
+	pprof.SetGoroutineLabels(ctx)
+}
+
+// Do runs f with the label key set to value on the calling goroutine, in
+// addition to the process-wide labels. Once f returns, the goroutine is left
+// with just the process-wide labels again, so labels of an enclosing Do are
+// not restored.
+func Do(key, value string, f func()) {
+	ctx, ok := base.Load().(context.Context)
+	if !ok {
+		ctx = context.Background()
+	}
+	pprof.Do(ctx, pprof.Labels(key, value), func(context.Context) { f() })
+}
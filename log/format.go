@@ -17,6 +17,7 @@ import (
 const (
 	timeFormat        = "2006-01-02T15:04:05-0700"
 	termTimeFormat    = "01-02|15:04:05.000"
+	logfmtTimeFormat  = "2006-01-02T15:04:05.000Z07:00" // RFC3339 with milliseconds
 	floatFormat       = 'f'
 	termMsgJust       = 40
 	termCtxMaxPadding = 40
@@ -28,7 +29,7 @@ var locationTrims = []string{
 }
 
 // PrintOrigins sets or unsets log location (file:line) printing for terminal
-// format output.
+// format output, and the "caller" field of JSON format output.
 func PrintOrigins(print bool) {
 	if print {
 		atomic.StoreUint32(&locationEnabled, 1)
@@ -37,6 +38,92 @@ func PrintOrigins(print bool) {
 	}
 }
 
+// PrintingOrigins reports whether log locations are printed, as last set by
+// PrintOrigins.
+func PrintingOrigins() bool {
+	return atomic.LoadUint32(&locationEnabled) != 0
+}
+
+// SetTimeFormat sets the Go time layout used for record timestamps by the
+// terminal, JSON and RFC3339 logfmt formats, overriding their defaults. An
+// empty layout restores the defaults. LogfmtFormat is not affected.
+func SetTimeFormat(layout string) {
+	timeLayout.Store(layout)
+}
+
+// timeLayout is the user requested timestamp layout, empty for the defaults.
+var timeLayout atomic.Value
+
+// formatTime formats a record timestamp with the user requested layout, or
+// with the given default if none was set.
+func formatTime(t time.Time, def string) string {
+	if layout, _ := timeLayout.Load().(string); layout != "" {
+		return t.Format(layout)
+	}
+	return t.Format(def)
+}
+
+// formatJSONTime returns the JSON representation of a record timestamp, which
+// is RFC3339 unless the user requested a different layout.
+func formatJSONTime(t time.Time) interface{} {
+	if layout, _ := timeLayout.Load().(string); layout != "" {
+		return t.Format(layout)
+	}
+	return t
+}
+
+// SetJSONMaxFieldSize limits string values in the output of all JSON formats,
+// including JSONFormatOrderedEx and GCPFormat, to size bytes. Longer values are
+// cut short, suffixed with an ellipsis and listed under the "truncated" key of
+// the record. Zero disables the limit.
+func SetJSONMaxFieldSize(size int) {
+	atomic.StoreInt64(&jsonMaxFieldSize, int64(size))
+}
+
+// jsonMaxFieldSize is the maximum length of JSON string values, 0 if unlimited.
+var jsonMaxFieldSize int64
+
+// truncateJSONValue cuts string values longer than limit bytes short on a rune
+// boundary, reporting whether the value was truncated.
+func truncateJSONValue(value interface{}, limit int) (interface{}, bool) {
+	s, ok := value.(string)
+	if !ok || limit <= 0 || len(s) <= limit {
+		return value, false
+	}
+	n := limit
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "...", true
+}
+
+// jsonFieldLimiter applies the JSON field size limit to the values of a single
+// record, collecting the keys of those which got truncated.
+type jsonFieldLimiter struct {
+	limit     int
+	truncated []string
+}
+
+func newJSONFieldLimiter() *jsonFieldLimiter {
+	return &jsonFieldLimiter{limit: int(atomic.LoadInt64(&jsonMaxFieldSize))}
+}
+
+// apply returns the value stored under key, truncated if it is an overlong string.
+func (l *jsonFieldLimiter) apply(key string, value interface{}) interface{} {
+	value, cut := truncateJSONValue(value, l.limit)
+	if cut {
+		l.truncated = append(l.truncated, key)
+	}
+	return value
+}
+
+// mark lists the truncated keys under the "truncated" key of props, if any.
+func (l *jsonFieldLimiter) mark(props map[string]interface{}) {
+	if len(l.truncated) > 0 {
+		props[truncatedKey] = l.truncated
+	}
+}
+
 // locationEnabled is an atomic flag controlling whether the terminal formatter
 // should append the log locations too when printing entries.
 var locationEnabled uint32
@@ -109,10 +196,7 @@ func TerminalFormat(usecolor bool) Format {
 		lvl := r.Lvl.AlignedString()
 		if atomic.LoadUint32(&locationEnabled) != 0 {
 			// Log origin printing was requested, format the location path and line number
-			location := fmt.Sprintf("%+v", r.Call)
-			for _, prefix := range locationTrims {
-				location = strings.TrimPrefix(location, prefix)
-			}
+			location := formatLocation(r)
 			// Maintain the maximum location length for fancyer alignment
 			align := int(atomic.LoadUint32(&locationLength))
 			if align < len(location) {
@@ -123,15 +207,15 @@ func TerminalFormat(usecolor bool) Format {
 
 			// Assemble and print the log heading
 			if color > 0 {
-				fmt.Fprintf(b, "\x1b[%dm%s\x1b[0m[%s|%s]%s %s ", color, lvl, r.Time.Format(termTimeFormat), location, padding, r.Msg)
+				fmt.Fprintf(b, "\x1b[%dm%s\x1b[0m[%s|%s]%s %s ", color, lvl, formatTime(r.Time, termTimeFormat), location, padding, r.Msg)
 			} else {
-				fmt.Fprintf(b, "%s[%s|%s]%s %s ", lvl, r.Time.Format(termTimeFormat), location, padding, r.Msg)
+				fmt.Fprintf(b, "%s[%s|%s]%s %s ", lvl, formatTime(r.Time, termTimeFormat), location, padding, r.Msg)
 			}
 		} else {
 			if color > 0 {
-				fmt.Fprintf(b, "\x1b[%dm%s\x1b[0m[%s] %s ", color, lvl, r.Time.Format(termTimeFormat), r.Msg)
+				fmt.Fprintf(b, "\x1b[%dm%s\x1b[0m[%s] %s ", color, lvl, formatTime(r.Time, termTimeFormat), r.Msg)
 			} else {
-				fmt.Fprintf(b, "%s[%s] %s ", lvl, r.Time.Format(termTimeFormat), r.Msg)
+				fmt.Fprintf(b, "%s[%s] %s ", lvl, formatTime(r.Time, termTimeFormat), r.Msg)
 			}
 		}
 		// try to justify the log output for short messages
@@ -145,6 +229,15 @@ func TerminalFormat(usecolor bool) Format {
 	})
 }
 
+// formatLocation returns the trimmed file:line location of the record's call site.
+func formatLocation(r *Record) string {
+	location := fmt.Sprintf("%+v", r.Call)
+	for _, prefix := range locationTrims {
+		location = strings.TrimPrefix(location, prefix)
+	}
+	return location
+}
+
 // LogfmtFormat prints records in logfmt format, an easy machine-parseable but human-readable
 // format for key/value pairs.
 //
@@ -159,6 +252,17 @@ func LogfmtFormat() Format {
 	})
 }
 
+// LogfmtFormatRFC3339 is like LogfmtFormat, but prints record timestamps in
+// RFC3339 format with milliseconds, or the layout set by SetTimeFormat.
+func LogfmtFormatRFC3339() Format {
+	return FormatFunc(func(r *Record) []byte {
+		common := []interface{}{r.KeyNames.Time, formatTime(r.Time, logfmtTimeFormat), r.KeyNames.Lvl, r.Lvl, r.KeyNames.Msg, r.Msg}
+		buf := &bytes.Buffer{}
+		logfmt(buf, append(common, r.Ctx...), 0, false)
+		return buf.Bytes()
+	})
+}
+
 func logfmt(buf *bytes.Buffer, ctx []interface{}, color int, term bool) {
 	for i := 0; i < len(ctx); i += 2 {
 		if i != 0 {
@@ -215,11 +319,13 @@ func JSONFormatOrderedEx(pretty, lineSeparated bool) Format {
 		}
 	}
 	return FormatFunc(func(r *Record) []byte {
-		props := make(map[string]interface{})
-
-		props[r.KeyNames.Time] = r.Time
+		var (
+			props   = make(map[string]interface{})
+			limiter = newJSONFieldLimiter()
+		)
+		props[r.KeyNames.Time] = formatJSONTime(r.Time)
 		props[r.KeyNames.Lvl] = r.Lvl.String()
-		props[r.KeyNames.Msg] = r.Msg
+		props[r.KeyNames.Msg] = limiter.apply(r.KeyNames.Msg, r.Msg)
 
 		ctx := make([]string, len(r.Ctx))
 		for i := 0; i < len(r.Ctx); i += 2 {
@@ -228,9 +334,10 @@ func JSONFormatOrderedEx(pretty, lineSeparated bool) Format {
 				props[errorKey] = fmt.Sprintf("%+v is not a string key,", r.Ctx[i])
 			}
 			ctx[i] = k
-			ctx[i+1] = formatLogfmtValue(r.Ctx[i+1], true)
+			ctx[i+1] = limiter.apply(k, formatLogfmtValue(r.Ctx[i+1], true)).(string)
 		}
 		props[r.KeyNames.Ctx] = ctx
+		limiter.mark(props)
 
 		b, err := jsonMarshal(props)
 		if err != nil {
@@ -258,19 +365,25 @@ func JSONFormatEx(pretty, lineSeparated bool) Format {
 	}
 
 	return FormatFunc(func(r *Record) []byte {
-		props := make(map[string]interface{})
-
-		props[r.KeyNames.Time] = r.Time
+		var (
+			props   = make(map[string]interface{})
+			limiter = newJSONFieldLimiter()
+		)
+		props[r.KeyNames.Time] = formatJSONTime(r.Time)
 		props[r.KeyNames.Lvl] = r.Lvl.String()
-		props[r.KeyNames.Msg] = r.Msg
+		props[r.KeyNames.Msg] = limiter.apply(r.KeyNames.Msg, r.Msg)
+		if atomic.LoadUint32(&locationEnabled) != 0 {
+			props[callerKey] = formatLocation(r)
+		}
 
 		for i := 0; i < len(r.Ctx); i += 2 {
 			k, ok := r.Ctx[i].(string)
 			if !ok {
 				props[errorKey] = fmt.Sprintf("%+v is not a string key", r.Ctx[i])
 			}
-			props[k] = formatJSONValue(r.Ctx[i+1])
+			props[k] = limiter.apply(k, formatJSONValue(r.Ctx[i+1]))
 		}
+		limiter.mark(props)
 
 		b, err := jsonMarshal(props)
 		if err != nil {
@@ -288,6 +401,58 @@ func JSONFormatEx(pretty, lineSeparated bool) Format {
 	})
 }
 
+// GCPFormat formats log records as JSON objects understood by Google Cloud
+// Logging, carrying the level as "severity", the message as "message" and all
+// context as string values of a "labels" object.
+func GCPFormat() Format {
+	return FormatFunc(func(r *Record) []byte {
+		var (
+			labels  = make(map[string]string)
+			limiter = newJSONFieldLimiter()
+		)
+		for i := 0; i < len(r.Ctx); i += 2 {
+			k, ok := r.Ctx[i].(string)
+			if !ok {
+				labels[errorKey] = fmt.Sprintf("%+v is not a string key", r.Ctx[i])
+				continue
+			}
+			labels[k] = limiter.apply(k, fmt.Sprint(formatJSONValue(r.Ctx[i+1]))).(string)
+		}
+		props := map[string]interface{}{
+			"severity": gcpSeverity(r.Lvl),
+			"message":  limiter.apply("message", r.Msg),
+			"time":     r.Time.Format(time.RFC3339Nano),
+		}
+		if len(labels) > 0 {
+			props["labels"] = labels
+		}
+		limiter.mark(props)
+		b, err := json.Marshal(props)
+		if err != nil {
+			b, _ = json.Marshal(map[string]string{
+				errorKey: err.Error(),
+			})
+		}
+		return append(b, '\n')
+	})
+}
+
+// gcpSeverity maps a log level to the corresponding Google Cloud Logging severity.
+func gcpSeverity(lvl Lvl) string {
+	switch lvl {
+	case LvlCrit:
+		return "CRITICAL"
+	case LvlError:
+		return "ERROR"
+	case LvlWarn:
+		return "WARNING"
+	case LvlInfo:
+		return "INFO"
+	default:
+		return "DEBUG"
+	}
+}
+
 func formatShared(value interface{}) (result interface{}) {
 	defer func() {
 		if err := recover(); err != nil {
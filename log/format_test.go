@@ -1,10 +1,16 @@
 package log
 
 import (
+	"encoding/json"
+	"fmt"
 	"math"
 	"math/big"
 	"math/rand"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-stack/stack"
 )
 
 func TestPrettyInt64(t *testing.T) {
@@ -93,3 +99,126 @@ func BenchmarkPrettyUint64Logfmt(b *testing.B) {
 		sink = FormatLogfmtUint64(rand.Uint64())
 	}
 }
+
+func TestJSONFormatCaller(t *testing.T) {
+	r := &Record{
+		Msg:      "test",
+		Call:     stack.Caller(0),
+		KeyNames: RecordKeyNames{Time: timeKey, Msg: msgKey, Lvl: lvlKey, Ctx: ctxKey},
+	}
+	for _, origins := range []bool{false, true} {
+		PrintOrigins(origins)
+
+		var props map[string]interface{}
+		if err := json.Unmarshal(JSONFormat().Format(r), &props); err != nil {
+			t.Fatalf("origins %v: failed to decode record: %v", origins, err)
+		}
+		caller, ok := props[callerKey]
+		if ok != origins {
+			t.Errorf("origins %v: caller field presence mismatch: have %v", origins, ok)
+		}
+		if origins && !strings.HasPrefix(caller.(string), "log/format_test.go:") {
+			t.Errorf("origins %v: caller mismatch: have %v", origins, caller)
+		}
+	}
+	PrintOrigins(false)
+}
+
+func TestJSONFormatMaxFieldSize(t *testing.T) {
+	defer SetJSONMaxFieldSize(0)
+
+	r := &Record{
+		Msg:      "test",
+		Ctx:      []interface{}{"blob", strings.Repeat("ab", 64), "num", 12345},
+		KeyNames: RecordKeyNames{Time: timeKey, Msg: msgKey, Lvl: lvlKey, Ctx: ctxKey},
+	}
+	SetJSONMaxFieldSize(16)
+
+	var props map[string]interface{}
+	if err := json.Unmarshal(JSONFormat().Format(r), &props); err != nil {
+		t.Fatalf("failed to decode record: %v", err)
+	}
+	if have, want := props["blob"], strings.Repeat("ab", 8)+"..."; have != want {
+		t.Errorf("truncated value mismatch: have %v, want %v", have, want)
+	}
+	if have := props["num"]; have != float64(12345) {
+		t.Errorf("non-string value changed: have %v", have)
+	}
+	if have, ok := props[truncatedKey].([]interface{}); !ok || len(have) != 1 || have[0] != "blob" {
+		t.Errorf("truncated marker mismatch: have %v", props[truncatedKey])
+	}
+}
+
+func TestJSONFormatOrderedMaxFieldSize(t *testing.T) {
+	defer SetJSONMaxFieldSize(0)
+
+	r := &Record{
+		Msg:      strings.Repeat("m", 32),
+		Ctx:      []interface{}{"blob", strings.Repeat("ab", 64), "num", 12345},
+		KeyNames: RecordKeyNames{Time: timeKey, Msg: msgKey, Lvl: lvlKey, Ctx: ctxKey},
+	}
+	SetJSONMaxFieldSize(16)
+
+	var props struct {
+		Msg       string   `json:"msg"`
+		Ctx       []string `json:"ctx"`
+		Truncated []string `json:"truncated"`
+	}
+	if err := json.Unmarshal(JSONFormatOrderedEx(false, true).Format(r), &props); err != nil {
+		t.Fatalf("failed to decode record: %v", err)
+	}
+	if have, want := props.Msg, strings.Repeat("m", 16)+"..."; have != want {
+		t.Errorf("truncated message mismatch: have %v, want %v", have, want)
+	}
+	if want := []string{"blob", strings.Repeat("ab", 8) + "...", "num", "12345"}; fmt.Sprint(props.Ctx) != fmt.Sprint(want) {
+		t.Errorf("context mismatch: have %v, want %v", props.Ctx, want)
+	}
+	if want := []string{msgKey, "blob"}; fmt.Sprint(props.Truncated) != fmt.Sprint(want) {
+		t.Errorf("truncated marker mismatch: have %v, want %v", props.Truncated, want)
+	}
+}
+
+func TestGCPFormatMaxFieldSize(t *testing.T) {
+	defer SetJSONMaxFieldSize(0)
+
+	r := &Record{
+		Msg: "test",
+		Ctx: []interface{}{"blob", strings.Repeat("ab", 64), "num", 12345},
+	}
+	SetJSONMaxFieldSize(16)
+
+	var props struct {
+		Message   string            `json:"message"`
+		Labels    map[string]string `json:"labels"`
+		Truncated []string          `json:"truncated"`
+	}
+	if err := json.Unmarshal(GCPFormat().Format(r), &props); err != nil {
+		t.Fatalf("failed to decode record: %v", err)
+	}
+	if props.Message != "test" {
+		t.Errorf("short message changed: have %v", props.Message)
+	}
+	if have, want := props.Labels["blob"], strings.Repeat("ab", 8)+"..."; have != want {
+		t.Errorf("truncated label mismatch: have %v, want %v", have, want)
+	}
+	if have := props.Labels["num"]; have != "12345" {
+		t.Errorf("short label changed: have %v", have)
+	}
+	if len(props.Truncated) != 1 || props.Truncated[0] != "blob" {
+		t.Errorf("truncated marker mismatch: have %v", props.Truncated)
+	}
+}
+
+func TestLogfmtFormatTime(t *testing.T) {
+	r := &Record{
+		Time:     time.Date(2022, 5, 16, 20, 58, 45, 123456789, time.FixedZone("", 2*3600)),
+		Msg:      "test",
+		KeyNames: RecordKeyNames{Time: timeKey, Msg: msgKey, Lvl: lvlKey, Ctx: ctxKey},
+	}
+	if have, want := string(LogfmtFormat().Format(r)), "t=2022-05-16T20:58:45+0200 "; !strings.HasPrefix(have, want) {
+		t.Errorf("logfmt timestamp mismatch: have %q, want prefix %q", have, want)
+	}
+	if have, want := string(LogfmtFormatRFC3339().Format(r)), "t=2022-05-16T20:58:45.123+02:00 "; !strings.HasPrefix(have, want) {
+		t.Errorf("RFC3339 logfmt timestamp mismatch: have %q, want prefix %q", have, want)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"os"
 	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-stack/stack"
 )
@@ -268,6 +270,142 @@ func BufferedHandler(bufSize int, h Handler) Handler {
 	return ChannelHandler(recs)
 }
 
+// droppedRecords is the number of records suppressed by all sampling and
+// deduplicating handlers.
+var droppedRecords uint64
+
+// DroppedRecords returns the total number of records suppressed by sampling
+// and deduplicating handlers since the process started.
+func DroppedRecords() uint64 {
+	return atomic.LoadUint64(&droppedRecords)
+}
+
+// SamplingHandler writes at most burst records with an identical level and
+// message to the wrapped handler per interval, dropping the rest. At the end
+// of every interval a summary record is written for each message that had
+// records suppressed. An interval starts with the first record after the last
+// one ended, so no timer is left running once the handler goes idle or is
+// discarded.
+func SamplingHandler(interval time.Duration, burst int, h Handler) Handler {
+	type sampleKey struct {
+		lvl Lvl
+		msg string
+	}
+	type sample struct {
+		count   int            // Number of records seen in the current interval
+		dropped int            // Number of records suppressed in the current interval
+		names   RecordKeyNames // Key names of the sampled records, used for the summary
+	}
+	var (
+		lock    sync.Mutex
+		samples = make(map[sampleKey]*sample)
+		started bool // Whether the current interval is running
+	)
+	flush := func() {
+		lock.Lock()
+		expired := samples
+		samples = make(map[sampleKey]*sample)
+		started = false
+		lock.Unlock()
+
+		for key, s := range expired {
+			if s.dropped == 0 {
+				continue
+			}
+			h.Log(&Record{
+				Time:     time.Now(),
+				Lvl:      key.lvl,
+				Msg:      "Suppressed repeated log messages",
+				Ctx:      []interface{}{"msg", key.msg, "count", s.dropped},
+				KeyNames: s.names,
+			})
+		}
+	}
+	return FuncHandler(func(r *Record) error {
+		key := sampleKey{r.Lvl, r.Msg}
+
+		lock.Lock()
+		if !started {
+			started = true
+			time.AfterFunc(interval, flush)
+		}
+		s := samples[key]
+		if s == nil {
+			s = &sample{names: r.KeyNames}
+			samples[key] = s
+		}
+		s.count++
+		if s.count > burst {
+			s.dropped++
+			atomic.AddUint64(&droppedRecords, 1)
+			lock.Unlock()
+			return nil
+		}
+		lock.Unlock()
+
+		return h.Log(r)
+	})
+}
+
+// DedupHandler suppresses records identical in level, message and context to
+// one already written to the wrapped handler within the current window. At the
+// end of every window a summary record is written for each record that had
+// duplicates suppressed. A window starts with the first record after the last
+// one ended, so no timer is left running once the handler goes idle or is
+// discarded.
+func DedupHandler(window time.Duration, h Handler) Handler {
+	type seen struct {
+		record  *Record // First record written in the current window
+		dropped int     // Number of identical records suppressed
+	}
+	var (
+		lock    sync.Mutex
+		records = make(map[string]*seen)
+		started bool // Whether the current window is running
+	)
+	flush := func() {
+		lock.Lock()
+		expired := records
+		records = make(map[string]*seen)
+		started = false
+		lock.Unlock()
+
+		for _, s := range expired {
+			if s.dropped == 0 {
+				continue
+			}
+			h.Log(&Record{
+				Time:     time.Now(),
+				Lvl:      s.record.Lvl,
+				Msg:      "Suppressed duplicate log messages",
+				Ctx:      []interface{}{"msg", s.record.Msg, "count", s.dropped},
+				KeyNames: s.record.KeyNames,
+			})
+		}
+	}
+	// Lazy values are evaluated before keying, as they would otherwise all be
+	// formatted as the address of their function.
+	return LazyHandler(FuncHandler(func(r *Record) error {
+		key := fmt.Sprintf("%d %s %v", r.Lvl, r.Msg, r.Ctx)
+
+		lock.Lock()
+		if !started {
+			started = true
+			time.AfterFunc(window, flush)
+		}
+		if s := records[key]; s != nil {
+			s.dropped++
+			lock.Unlock()
+			atomic.AddUint64(&droppedRecords, 1)
+			return nil
+		}
+		records[key] = &seen{record: r}
+		lock.Unlock()
+
+		return h.Log(r)
+	}))
+}
+
 // LazyHandler writes all values to the wrapped handler after evaluating
 // any lazy functions in the record's context. It is already wrapped
 // around StreamHandler and SyslogHandler in this library, you'll only need
@@ -0,0 +1,128 @@
+package log
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingHandler collects all records written to it.
+type recordingHandler struct {
+	lock    sync.Mutex
+	records []*Record
+}
+
+func (h *recordingHandler) Log(r *Record) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+// waitFor waits until a record with the given message was written, returning
+// the records written until then.
+func (h *recordingHandler) waitFor(t *testing.T, msg string) []*Record {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		h.lock.Lock()
+		records := append([]*Record(nil), h.records...)
+		h.lock.Unlock()
+
+		for _, r := range records {
+			if r.Msg == msg {
+				return records
+			}
+		}
+	}
+	t.Fatalf("no %q record written", msg)
+	return nil
+}
+
+func TestDedupHandler(t *testing.T) {
+	rec := new(recordingHandler)
+	h := DedupHandler(50*time.Millisecond, rec)
+	write := func(msg string, ctx ...interface{}) {
+		h.Log(&Record{Lvl: LvlInfo, Msg: msg, Ctx: ctx})
+	}
+	write("dup", "k", 1)
+	write("dup", "k", 1)
+	write("dup", "k", 1)
+	write("dup", "k", 2)
+
+	// Lazy values must be told apart by their result, not their function.
+	var n int
+	lazy := Lazy{func() int { n++; return n }}
+	write("lazy", "v", lazy)
+	write("lazy", "v", lazy)
+
+	records := rec.waitFor(t, "Suppressed duplicate log messages")
+	want := []struct {
+		msg string
+		ctx []interface{}
+	}{
+		{"dup", []interface{}{"k", 1}},
+		{"dup", []interface{}{"k", 2}},
+		{"lazy", []interface{}{"v", 1}},
+		{"lazy", []interface{}{"v", 2}},
+		{"Suppressed duplicate log messages", []interface{}{"msg", "dup", "count", 2}},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("record count mismatch: have %d, want %d", len(records), len(want))
+	}
+	for i, r := range records {
+		if r.Msg != want[i].msg || len(r.Ctx) != len(want[i].ctx) {
+			t.Fatalf("record %d mismatch: have %s %v, want %s %v", i, r.Msg, r.Ctx, want[i].msg, want[i].ctx)
+		}
+		for j := range r.Ctx {
+			if r.Ctx[j] != want[i].ctx[j] {
+				t.Fatalf("record %d mismatch: have %s %v, want %s %v", i, r.Msg, r.Ctx, want[i].msg, want[i].ctx)
+			}
+		}
+	}
+}
+
+func TestSamplingHandler(t *testing.T) {
+	rec := new(recordingHandler)
+	h := SamplingHandler(50*time.Millisecond, 2, rec)
+
+	dropped := DroppedRecords()
+	for i := 0; i < 5; i++ {
+		h.Log(&Record{Lvl: LvlWarn, Msg: "noisy", Ctx: []interface{}{"i", i}})
+	}
+	h.Log(&Record{Lvl: LvlInfo, Msg: "noisy"}) // different level, sampled apart
+	h.Log(&Record{Lvl: LvlWarn, Msg: "quiet"})
+
+	if have := DroppedRecords() - dropped; have != 3 {
+		t.Errorf("dropped record count mismatch: have %d, want 3", have)
+	}
+	records := rec.waitFor(t, "Suppressed repeated log messages")
+	var msgs []string
+	for _, r := range records {
+		msgs = append(msgs, r.Lvl.String()+" "+r.Msg)
+	}
+	want := []string{"warn noisy", "warn noisy", "info noisy", "warn quiet", "warn Suppressed repeated log messages"}
+	if len(msgs) != len(want) {
+		t.Fatalf("records mismatch: have %v, want %v", msgs, want)
+	}
+	for i := range want {
+		if msgs[i] != want[i] {
+			t.Fatalf("records mismatch: have %v, want %v", msgs, want)
+		}
+	}
+	if i := records[1].Ctx[1]; i != 1 {
+		t.Errorf("burst should pass the first records through, have i=%v", i)
+	}
+	if summary := records[4]; summary.Ctx[1] != "noisy" || summary.Ctx[3] != 3 {
+		t.Errorf("summary mismatch: have %v", summary.Ctx)
+	}
+
+	// The next interval lets a full burst through again.
+	h.Log(&Record{Lvl: LvlWarn, Msg: "noisy"})
+	h.Log(&Record{Lvl: LvlWarn, Msg: "noisy"})
+	rec.lock.Lock()
+	total := len(rec.records)
+	rec.lock.Unlock()
+	if total != len(want)+2 {
+		t.Errorf("records after interval reset: have %d, want %d", total, len(want)+2)
+	}
+}
@@ -12,6 +12,8 @@ const timeKey = "t"
 const lvlKey = "lvl"
 const msgKey = "msg"
 const ctxKey = "ctx"
+const callerKey = "caller"
+const truncatedKey = "truncated"
 const errorKey = "LOG15_ERROR"
 const skipLevel = 2
 
@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+)
+
+// BucketHistograms count values into buckets with fixed upper bounds, in the
+// way Prometheus histograms do. Unlike sampled Histograms, no values are lost,
+// which keeps the bucket counts exact across scrapes.
+type BucketHistogram interface {
+	Bounds() []float64
+	Counts() []int64
+	Count() int64
+	Snapshot() BucketHistogram
+	Sum() float64
+	Update(float64)
+}
+
+// GetOrRegisterBucketHistogram returns an existing BucketHistogram or
+// constructs and registers a new StandardBucketHistogram.
+func GetOrRegisterBucketHistogram(name string, r Registry, bounds []float64) BucketHistogram {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, func() BucketHistogram { return NewBucketHistogram(bounds) }).(BucketHistogram)
+}
+
+// NewBucketHistogram constructs a new StandardBucketHistogram with the given
+// bucket upper bounds, which are sorted if necessary.
+func NewBucketHistogram(bounds []float64) BucketHistogram {
+	if !Enabled {
+		return NilBucketHistogram{}
+	}
+	bounds = append([]float64(nil), bounds...)
+	sort.Float64s(bounds)
+	return &StandardBucketHistogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)),
+	}
+}
+
+// NewRegisteredBucketHistogram constructs and registers a new
+// StandardBucketHistogram.
+func NewRegisteredBucketHistogram(name string, r Registry, bounds []float64) BucketHistogram {
+	c := NewBucketHistogram(bounds)
+	if nil == r {
+		r = DefaultRegistry
+	}
+	r.Register(name, c)
+	return c
+}
+
+// BucketHistogramSnapshot is a read-only copy of another BucketHistogram.
+type BucketHistogramSnapshot struct {
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+// Bounds returns the upper bounds of the buckets.
+func (h *BucketHistogramSnapshot) Bounds() []float64 { return h.bounds }
+
+// Counts returns the cumulative number of values at or below each bound at
+// the time the snapshot was taken.
+func (h *BucketHistogramSnapshot) Counts() []int64 { return h.counts }
+
+// Count returns the number of values recorded at the time the snapshot was
+// taken.
+func (h *BucketHistogramSnapshot) Count() int64 { return h.count }
+
+// Snapshot returns the snapshot.
+func (h *BucketHistogramSnapshot) Snapshot() BucketHistogram { return h }
+
+// Sum returns the sum of the values recorded at the time the snapshot was
+// taken.
+func (h *BucketHistogramSnapshot) Sum() float64 { return h.sum }
+
+// Update panics.
+func (*BucketHistogramSnapshot) Update(float64) {
+	panic("Update called on a BucketHistogramSnapshot")
+}
+
+// NilBucketHistogram is a no-op BucketHistogram.
+type NilBucketHistogram struct{}
+
+// Bounds is a no-op.
+func (NilBucketHistogram) Bounds() []float64 { return nil }
+
+// Counts is a no-op.
+func (NilBucketHistogram) Counts() []int64 { return nil }
+
+// Count is a no-op.
+func (NilBucketHistogram) Count() int64 { return 0 }
+
+// Snapshot is a no-op.
+func (NilBucketHistogram) Snapshot() BucketHistogram { return NilBucketHistogram{} }
+
+// Sum is a no-op.
+func (NilBucketHistogram) Sum() float64 { return 0 }
+
+// Update is a no-op.
+func (NilBucketHistogram) Update(float64) {}
+
+// StandardBucketHistogram is the standard implementation of a BucketHistogram
+// and uses a sync.Mutex to manage the bucket counts.
+type StandardBucketHistogram struct {
+	mutex  sync.Mutex
+	bounds []float64
+	counts []int64 // Non-cumulative number of values per bucket
+	count  int64
+	sum    float64
+}
+
+// Bounds returns the upper bounds of the buckets.
+func (h *StandardBucketHistogram) Bounds() []float64 { return h.bounds }
+
+// Counts returns the cumulative number of values at or below each bound.
+func (h *StandardBucketHistogram) Counts() []int64 {
+	return h.Snapshot().Counts()
+}
+
+// Count returns the number of values recorded.
+func (h *StandardBucketHistogram) Count() int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.count
+}
+
+// Snapshot returns a read-only copy of the histogram.
+func (h *StandardBucketHistogram) Snapshot() BucketHistogram {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	counts := make([]int64, len(h.counts))
+	var total int64
+	for i, n := range h.counts {
+		total += n
+		counts[i] = total
+	}
+	return &BucketHistogramSnapshot{
+		bounds: h.bounds,
+		counts: counts,
+		count:  h.count,
+		sum:    h.sum,
+	}
+}
+
+// Sum returns the sum of the values recorded.
+func (h *StandardBucketHistogram) Sum() float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.sum
+}
+
+// Update records a value in the smallest bucket whose bound is not below it.
+// Values above the largest bound are only reflected in Count and Sum.
+func (h *StandardBucketHistogram) Update(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
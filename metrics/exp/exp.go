@@ -6,7 +6,9 @@ import (
 	"expvar"
 	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
@@ -16,11 +18,14 @@ import (
 type exp struct {
 	expvarLock sync.Mutex // expvar panics if you try to register the same var twice, so we must probe it safely
 	registry   metrics.Registry
+	lazy       bool // Whether the vars are synced in the background instead of per request
 }
 
 func (exp *exp) expHandler(w http.ResponseWriter, r *http.Request) {
-	// load our variables into expvar
-	exp.syncToExpvar()
+	// load our variables into expvar, unless a background sync does so
+	if !exp.lazy {
+		exp.syncToExpvar()
+	}
 
 	// now just run the official expvar handler code (which is not publicly callable, so pasted inline)
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -49,7 +54,21 @@ func Exp(r metrics.Registry) {
 
 // ExpHandler will return an expvar powered metrics handler.
 func ExpHandler(r metrics.Registry) http.Handler {
-	e := exp{sync.Mutex{}, r}
+	e := exp{registry: r}
+	return http.HandlerFunc(e.expHandler)
+}
+
+// ExpHandlerLazy will return an expvar powered metrics handler, which serves
+// the vars synced from the registry by a background goroutine every refresh
+// interval instead of loading the whole registry on each request.
+func ExpHandlerLazy(r metrics.Registry, refresh time.Duration) http.Handler {
+	e := &exp{registry: r, lazy: true}
+	e.syncToExpvar()
+	go func() {
+		for range time.Tick(refresh) {
+			e.syncToExpvar()
+		}
+	}()
 	return http.HandlerFunc(e.expHandler)
 }
 
@@ -123,6 +142,16 @@ func (exp *exp) publishHistogram(name string, metric metrics.Histogram) {
 	exp.getFloat(name + ".999-percentile").Set(ps[4])
 }
 
+func (exp *exp) publishBucketHistogram(name string, metric metrics.BucketHistogram) {
+	h := metric.Snapshot()
+	exp.getInt(name + ".count").Set(h.Count())
+	exp.getFloat(name + ".sum").Set(h.Sum())
+	counts := h.Counts()
+	for i, bound := range h.Bounds() {
+		exp.getInt(name + ".le-" + strconv.FormatFloat(bound, 'f', -1, 64)).Set(counts[i])
+	}
+}
+
 func (exp *exp) publishMeter(name string, metric metrics.Meter) {
 	m := metric.Snapshot()
 	exp.getInt(name + ".count").Set(m.Count())
@@ -173,6 +202,8 @@ func (exp *exp) syncToExpvar() {
 			exp.publishGaugeFloat64(name, i)
 		case metrics.Histogram:
 			exp.publishHistogram(name, i)
+		case metrics.BucketHistogram:
+			exp.publishBucketHistogram(name, i)
 		case metrics.Meter:
 			exp.publishMeter(name, i)
 		case metrics.Timer:
@@ -124,3 +124,38 @@ func CollectProcessMetrics(refresh time.Duration) {
 		time.Sleep(refresh)
 	}
 }
+
+// CollectRuntimeMetrics periodically collects Go runtime heap, stack and garbage
+// collector statistics. It complements CollectProcessMetrics, which already
+// tracks the goroutine count, allocations, GC pauses and used and held memory,
+// so none of those are duplicated here.
+func CollectRuntimeMetrics(refresh time.Duration) {
+	// Short circuit if the metrics system is disabled
+	if !Enabled {
+		return
+	}
+	var (
+		memstats = new(runtime.MemStats)
+
+		heapObjects = GetOrRegisterGauge("system/memory/heapobjects", DefaultRegistry)
+		heapSys     = GetOrRegisterGauge("system/memory/heapsys", DefaultRegistry)
+		stackInuse  = GetOrRegisterGauge("system/memory/stackinuse", DefaultRegistry)
+
+		gcCount       = GetOrRegisterGauge("system/gc/count", DefaultRegistry)
+		gcLastPause   = GetOrRegisterGauge("system/gc/lastpause", DefaultRegistry)
+		gcCPUFraction = GetOrRegisterGaugeFloat64("system/gc/cpufraction", DefaultRegistry)
+	)
+	for {
+		runtime.ReadMemStats(memstats)
+
+		heapObjects.Update(int64(memstats.HeapObjects))
+		heapSys.Update(int64(memstats.HeapSys))
+		stackInuse.Update(int64(memstats.StackInuse))
+
+		gcCount.Update(int64(memstats.NumGC))
+		gcLastPause.Update(int64(memstats.PauseNs[(memstats.NumGC+255)%256]))
+		gcCPUFraction.Update(memstats.GCCPUFraction)
+
+		time.Sleep(refresh)
+	}
+}
@@ -29,8 +29,10 @@ var (
 	typeGaugeTpl           = "# TYPE %s gauge\n"
 	typeCounterTpl         = "# TYPE %s counter\n"
 	typeSummaryTpl         = "# TYPE %s summary\n"
+	typeHistogramTpl       = "# TYPE %s histogram\n"
 	keyValueTpl            = "%s %v\n\n"
 	keyQuantileTagValueTpl = "%s {quantile=\"%s\"} %v\n"
+	keyBucketTagValueTpl   = "%s_bucket {le=\"%s\"} %v\n"
 )
 
 // collector is a collection of byte buffers that aggregate Prometheus reports
@@ -69,6 +71,19 @@ func (c *collector) addHistogram(name string, m metrics.Histogram) {
 	c.buff.WriteRune('\n')
 }
 
+func (c *collector) addBucketHistogram(name string, m metrics.BucketHistogram) {
+	key := mutateKey(name)
+	c.buff.WriteString(fmt.Sprintf(typeHistogramTpl, key))
+	counts := m.Counts()
+	for i, bound := range m.Bounds() {
+		c.buff.WriteString(fmt.Sprintf(keyBucketTagValueTpl, key, strconv.FormatFloat(bound, 'f', -1, 64), counts[i]))
+	}
+	c.buff.WriteString(fmt.Sprintf(keyBucketTagValueTpl, key, "+Inf", m.Count()))
+	c.buff.WriteString(fmt.Sprintf("%s_sum %v\n", key, m.Sum()))
+	c.buff.WriteString(fmt.Sprintf("%s_count %v\n", key, m.Count()))
+	c.buff.WriteRune('\n')
+}
+
 func (c *collector) addMeter(name string, m metrics.Meter) {
 	c.writeGaugeCounter(name, m.Count())
 }
@@ -31,6 +31,13 @@ func TestCollector(t *testing.T) {
 	histogram := metrics.NewHistogram(&metrics.NilSample{})
 	c.addHistogram("test/histogram", histogram)
 
+	bucketHistogram := metrics.NewBucketHistogram([]float64{0.5, 0.1, 1})
+	bucketHistogram.Update(0.05)
+	bucketHistogram.Update(0.3)
+	bucketHistogram.Update(0.4)
+	bucketHistogram.Update(2)
+	c.addBucketHistogram("test/bucket_histogram", bucketHistogram.Snapshot())
+
 	meter := metrics.NewMeter()
 	defer meter.Stop()
 	meter.Mark(9999999)
@@ -78,6 +85,14 @@ test_histogram {quantile="0.99"} 0
 test_histogram {quantile="0.999"} 0
 test_histogram {quantile="0.9999"} 0
 
+# TYPE test_bucket_histogram histogram
+test_bucket_histogram_bucket {le="0.1"} 1
+test_bucket_histogram_bucket {le="0.5"} 3
+test_bucket_histogram_bucket {le="1"} 3
+test_bucket_histogram_bucket {le="+Inf"} 4
+test_bucket_histogram_sum 2.75
+test_bucket_histogram_count 4
+
 # TYPE test_meter gauge
 test_meter 9999999
 
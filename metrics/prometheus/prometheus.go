@@ -51,6 +51,8 @@ func Handler(reg metrics.Registry) http.Handler {
 				c.addGaugeFloat64(name, m.Snapshot())
 			case metrics.Histogram:
 				c.addHistogram(name, m.Snapshot())
+			case metrics.BucketHistogram:
+				c.addBucketHistogram(name, m.Snapshot())
 			case metrics.Meter:
 				c.addMeter(name, m.Snapshot())
 			case metrics.Timer:
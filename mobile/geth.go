@@ -125,7 +125,9 @@ func NewNode(datadir string, config *NodeConfig) (stack *Node, _ error) {
 	}
 
 	if config.PprofAddress != "" {
-		debug.StartPProf(config.PprofAddress, true)
+		if err := debug.StartPProf(config.PprofAddress, true); err != nil {
+			return nil, err
+		}
 	}
 
 	// Create the empty networking stack